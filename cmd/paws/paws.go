@@ -9,12 +9,48 @@ import (
 	//_ "github.com/gofiber/fiber/v2/middleware/recover" // to trap panics
 	//"github.com/gofiber/fiber/v2/utils"
 	//"github.com/gofiber/template/html"
+	"flag"
 	"github.com/gin-gonic/gin"
+	"os"
 	"pacb.com/seq/paws/pkg/web"
 	"runtime" // only for GOOS
 )
 
+// Settings come from DefaultConfig, then the -config file, then PAWS_*
+// environment variables, then flags, each overriding the one before.
+func loadConfig() (web.Config, error) {
+	configPath := flag.String("config", os.Getenv("PAWS_CONFIG"), "YAML (or JSON) file of settings")
+	settings := make(map[string]string) // by flag name
+	for _, name := range web.ConfigNames() {
+		settings[web.FlagName(name)] = name
+		flag.String(web.FlagName(name), "", "overrides "+name+" (or "+web.EnvName(name)+")")
+	}
+	flag.Parse()
+
+	config, err := web.LoadConfig(*configPath)
+	if err != nil {
+		return config, err
+	}
+	if err := config.ApplyEnv(os.LookupEnv); err != nil {
+		return config, err
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if name, ok := settings[f.Name]; ok && err == nil {
+			err = config.Set(name, f.Value.String())
+		}
+	})
+	if err != nil {
+		return config, err
+	}
+	return config, config.Validate()
+}
+
 func main() {
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	//router := gin.Default()
 	// Or explicitly:
 	router := gin.New()
@@ -35,7 +71,18 @@ func main() {
 		c.String(200, runtime.GOOS)
 	})
 
-	web.AddRoutes(router)
+	// Swagger UI for GET /docs, served from here so that it works
+	// without internet access.
+	if config.SwaggerUiDir != "" {
+		router.Static("/swagger-ui", config.SwaggerUiDir)
+	}
+
+	state, err := web.AddRoutes(router, config)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	log.Fatal(router.Run(":5000")) // logger maybe not needed, but does not seem to hurt
+	err = router.Run(config.ListenAddress)
+	state.Close()
+	log.Fatal(err) // logger maybe not needed, but does not seem to hurt
 }
@@ -0,0 +1,98 @@
+// Package resolver maps the URLs that pa-ws clients send, like
+// http://localhost:23632/storages/m123456_987654/thefile.baz, to local
+// paths that can go on the command line of a child process.
+package resolver
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Discard is the path of "discard:" URLs, which name a sink for output nobody wants.
+const Discard = os.DevNull
+
+// Storages finds the directory allocated to a movie.
+type Storages interface {
+	Dir(mid string) (string, error)
+}
+
+// UnsupportedError is returned for a URL that cannot name a local file.
+type UnsupportedError struct {
+	Url    string
+	Reason string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("unsupported URL %q: %s", e.Url, e.Reason)
+}
+
+// Resolver maps URLs to local paths. These forms are understood:
+//
+//	discard:
+//	file:/data/pa/foo.h5, file:///data/pa/foo.h5, file://localhost/data/pa/foo.h5
+//	http://host:port/storages/<mid>/<path in the movie directory>
+type Resolver struct {
+	storages Storages
+}
+
+// New returns a Resolver that looks up /storages/ URLs in storages.
+func New(storages Storages) *Resolver {
+	return &Resolver{storages: storages}
+}
+
+// IsDiscard tells whether rawurl is a "discard:" URL.
+func IsDiscard(rawurl string) bool {
+	return strings.HasPrefix(rawurl, "discard:")
+}
+
+// Path returns the local path that rawurl names.
+func (r *Resolver) Path(rawurl string) (string, error) {
+	if rawurl == "" {
+		return "", &UnsupportedError{rawurl, "empty"}
+	}
+	if IsDiscard(rawurl) {
+		return Discard, nil
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", &UnsupportedError{rawurl, err.Error()}
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Host != "" && u.Host != "localhost" {
+			return "", &UnsupportedError{rawurl, "file on another host"}
+		}
+		if u.Path == "" && u.Opaque != "" {
+			return "", &UnsupportedError{rawurl, "relative path"}
+		}
+		return filepath.FromSlash(path.Clean(u.Path)), nil
+	case "http", "https":
+		return r.storagePath(rawurl, u.Path)
+	case "":
+		return "", &UnsupportedError{rawurl, "no scheme"}
+	default:
+		return "", &UnsupportedError{rawurl, "scheme " + u.Scheme}
+	}
+}
+
+// Maps /storages/<mid>/<rest> into the movie directory. Cleaning rest
+// as an absolute path first means ".." cannot climb out of it.
+func (r *Resolver) storagePath(rawurl, upath string) (string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(upath, "/"), "/", 3)
+	if len(parts) < 2 || parts[0] != "storages" || parts[1] == "" {
+		return "", &UnsupportedError{rawurl, "not under /storages/<mid>"}
+	}
+	dir, err := r.storages.Dir(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", rawurl, err)
+	}
+	if len(parts) == 2 {
+		return dir, nil
+	}
+	rest := path.Clean("/" + parts[2])
+	return filepath.Join(dir, filepath.FromSlash(rest)), nil
+}
@@ -0,0 +1,55 @@
+package resolver
+
+import (
+	"errors"
+	"testing"
+)
+
+type fakeStorages map[string]string
+
+func (f fakeStorages) Dir(mid string) (string, error) {
+	dir, ok := f[mid]
+	if !ok {
+		return "", errors.New("storage not found")
+	}
+	return dir, nil
+}
+
+func TestPath(t *testing.T) {
+	r := New(fakeStorages{"m123456_987654": "/data/pa/m123456_987654"})
+	tests := map[string]string{
+		"discard:":                                                           Discard,
+		"file:/data/pa/sample_file.trc.h5":                                   "/data/pa/sample_file.trc.h5",
+		"file:///data/pa/sample_file.trc.h5":                                 "/data/pa/sample_file.trc.h5",
+		"file://localhost/data/pa/sample_file.trc.h5":                        "/data/pa/sample_file.trc.h5",
+		"http://localhost:23632/storages/m123456_987654/thefile.baz":         "/data/pa/m123456_987654/thefile.baz",
+		"http://pa-ws:23632/storages/m123456_987654/sub/dir/x.bam":           "/data/pa/m123456_987654/sub/dir/x.bam",
+		"http://localhost:23632/storages/m123456_987654":                     "/data/pa/m123456_987654",
+		"http://localhost:23632/storages/m123456_987654/../../../etc/passwd": "/data/pa/m123456_987654/etc/passwd",
+		"http://localhost:23632/storages/m123456_987654/with%20space.log":    "/data/pa/m123456_987654/with space.log",
+	}
+	for url, want := range tests {
+		got, err := r.Path(url)
+		if err != nil {
+			t.Errorf("%s: %v", url, err)
+		} else if got != want {
+			t.Errorf("%s: got %s, want %s", url, got, want)
+		}
+	}
+}
+
+func TestPathErrors(t *testing.T) {
+	r := New(fakeStorages{})
+	for _, url := range []string{
+		"",
+		"/data/pa/foo",
+		"ftp://localhost/foo",
+		"file://otherhost/data/foo",
+		"http://localhost:23632/m123456_98765/foo.baz",
+		"http://localhost:23632/storages/m999/foo.baz",
+	} {
+		if got, err := r.Path(url); err == nil {
+			t.Errorf("%q: got %s", url, got)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"pacb.com/seq/paws/pkg/resolver"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// socketApp is one of the "one shot" apps that run on each socket.
+type socketApp struct {
+	name   string
+	object func(*SocketObject) interface{}
+	status func(*SocketObject) *ProcessStatusObject
+	clear  func(*SocketObject)
+
+	// Sets the object of the app on s to the one on from
+	restore func(s, from *SocketObject)
+
+	// Called once the process of the app on socket id is running, if not nil
+	started func(st *State, id string, p *Process)
+}
+
+var (
+	basecallerApp = socketApp{
+		"basecaller",
+		func(s *SocketObject) interface{} { return s.Basecaller },
+		func(s *SocketObject) *ProcessStatusObject { return &s.Basecaller.ProcessStatus },
+		func(s *SocketObject) { s.Basecaller = SocketBasecallerObject{} },
+		func(s, from *SocketObject) { s.Basecaller = from.Basecaller },
+		(*State).watchRtMetrics,
+	}
+	darkcalApp = socketApp{
+		"darkcal",
+		func(s *SocketObject) interface{} { return s.Darkcal },
+		func(s *SocketObject) *ProcessStatusObject { return &s.Darkcal.ProcessStatus },
+		func(s *SocketObject) { s.Darkcal = SocketDarkcalObject{} },
+		func(s, from *SocketObject) { s.Darkcal = from.Darkcal },
+		nil,
+	}
+	loadingcalApp = socketApp{
+		"loadingcal",
+		func(s *SocketObject) interface{} { return s.Loadingcal },
+		func(s *SocketObject) *ProcessStatusObject { return &s.Loadingcal.ProcessStatus },
+		func(s *SocketObject) { s.Loadingcal = SocketLoadingcalObject{} },
+		func(s, from *SocketObject) { s.Loadingcal = from.Loadingcal },
+		nil,
+	}
+)
+
+// Fields that the app on socket s has in common with the others.
+func (app socketApp) common(s *SocketObject) socketCommonObject {
+	switch obj := app.object(s).(type) {
+	case SocketBasecallerObject:
+		return obj.socketCommonObject
+	case SocketDarkcalObject:
+		return obj.socketCommonObject
+	case SocketLoadingcalObject:
+		return obj.socketCommonObject
+	}
+	return socketCommonObject{}
+}
+
+// The URLs the app on socket s reads or writes.
+func (app socketApp) urls(s *SocketObject) []string {
+	switch obj := app.object(s).(type) {
+	case SocketBasecallerObject:
+		return []string{obj.BazUrl, obj.TraceFileUrl, obj.DarkCalFileUrl, obj.SimulationFileUrl, obj.LogUrl}
+	case SocketDarkcalObject:
+		return []string{obj.CalibFileUrl, obj.LogUrl}
+	case SocketLoadingcalObject:
+		return []string{obj.DarkFrameFileUrl, obj.CalibFileUrl, obj.LogUrl}
+	}
+	return nil
+}
+
+// Whether the app on socket s reads or writes the storage of mid.
+func (app socketApp) uses(s *SocketObject, mid string) bool {
+	return app.common(s).Mid == mid || inStorage(mid, app.urls(s))
+}
+
+// Supervisor key of the app on socket id.
+func (app socketApp) key(id string) string {
+	return "sockets/" + id + "/" + app.name
+}
+
+// Path of the app resource on socket id, for error messages.
+func (app socketApp) resource(id string) string {
+	return "/" + app.key(id)
+}
+
+// Moves the app on socket s to READY with a cleared object, unless
+// that is an illegal transition. The caller holds the registry lock.
+func (app socketApp) reset(s *SocketObject) error {
+	status := app.status(s)
+	if err := checkTransition(app.resource(s.SocketId), status.ExecutionStatus, Ready); err != nil {
+		return err
+	}
+	app.clear(s)
+	*app.status(s) = readyStatus()
+	return nil
+}
+
+var socketApps = []socketApp{darkcalApp, loadingcalApp, basecallerApp}
+
+// Checks that every app on socket s can be reset, without changing
+// anything, and returns the conflicts.
+func resetConflicts(s *SocketObject) []*TransitionError {
+	var conflicts []*TransitionError
+	for _, app := range socketApps {
+		err := checkTransition(app.resource(s.SocketId), app.status(s).ExecutionStatus, Ready)
+		if err != nil {
+			conflicts = append(conflicts, err.(*TransitionError))
+		}
+	}
+	return conflicts
+}
+
+// Launches the app on socket id. The caller has already stored the
+// requested object in the registry; from here on its process status
+// follows the child, and its output goes to its log, if that opens.
+func (st *State) launch(app socketApp, id, path string, args []string) error {
+	s, _ := st.Sockets.Get(id)
+	log := st.socketLog(app, &s, false)
+	p, err := st.Processes.Start(app.key(id), path, args, log.output(), log.closing(app.follow(st.Sockets, id)))
+	if err != nil {
+		log.Close()
+		return err
+	}
+	st.pollLog(log, p)
+	if app.started != nil {
+		app.started(st, id, p)
+	}
+	return nil
+}
+
+// Returns the callback that keeps the status of the app on socket id
+// in sockets in step with its process.
+func (app socketApp) follow(sockets *SocketRegistry, id string) func(ProcessStatusObject) {
+	return func(status ProcessStatusObject) {
+		sockets.Update(id, func(s *SocketObject) error {
+			*app.status(s) = status
+			return nil
+		})
+	}
+}
+
+// Stops the process under key with the configured signal and grace period.
+// Returns false if nothing was ever started there.
+func (st *State) stopProcess(key string) bool {
+	sig, err := ParseSignal(st.Config.StopSignal)
+	if err != nil {
+		sig = syscall.SIGTERM
+	}
+	return st.Processes.Stop(key, sig, st.Config.StopGracePeriod)
+}
+
+// Builds the command line of a child process. URLs are resolved to
+// local paths; the first one that cannot be is kept as the error.
+type cmdline struct {
+	resolver *resolver.Resolver
+	args     []string
+	err      error
+}
+
+// Adds flag and value, unless value is empty.
+func (c *cmdline) add(flag, value string) {
+	if value != "" {
+		c.args = append(c.args, flag, value)
+	}
+}
+
+// Adds flag and the path url resolves to, unless url is empty.
+func (c *cmdline) addPath(flag, url string) {
+	if url == "" || c.err != nil {
+		return
+	}
+	path, err := c.resolver.Path(url)
+	if err != nil {
+		c.err = err
+		return
+	}
+	c.add(flag, path)
+}
+
+// Command-line for smrt_basecaller, derived from the request.
+func (st *State) basecallerArgs(obj SocketBasecallerObject) ([]string, error) {
+	c := cmdline{resolver: st.Resolver}
+	c.add("--uuid", obj.Uuid)
+	c.add("--chiplayout", obj.Chiplayout)
+	c.addPath("--darkcalfile", obj.DarkCalFileUrl)
+	c.addPath("--inputfile", obj.SimulationFileUrl)
+	c.addPath("--outputbazfile", obj.BazUrl)
+	if !resolver.IsDiscard(obj.TraceFileUrl) {
+		c.addPath("--outputtrcfile", obj.TraceFileUrl)
+	}
+	c.add("--sequencingroi", joinInts(obj.SequencingRoi))
+	c.add("--tracefileroi", joinInts(obj.TraceFileRoi))
+	if obj.PixelSpreadFunction != nil {
+		c.add("--psf", mustMarshal(obj.PixelSpreadFunction))
+	}
+	if obj.CrosstalkFilter != nil {
+		c.add("--crosstalk", mustMarshal(obj.CrosstalkFilter))
+	}
+	if obj.Analogs != nil {
+		c.add("--analogs", mustMarshal(obj.Analogs))
+	}
+	c.add("--framerate", positive(int64(obj.ExpectedFrameRate)))
+	if obj.PhotoelectronSensitivity > 0 {
+		c.add("--photoelectronsensitivity", strconv.FormatFloat(obj.PhotoelectronSensitivity, 'g', -1, 64))
+	}
+	c.add("--refsnr", positive(int64(obj.RefSnr)))
+	c.add("--movienum", strconv.Itoa(int(obj.MovieNumber)))
+	c.add("--maxframes", positive(int64(obj.MaxMovieFrames)))
+	c.add("--maxseconds", positive(int64(obj.MaxMovieSeconds)))
+	c.add("--config", obj.SmrtBasecallerConfig)
+	return c.args, c.err
+}
+
+// Command-line for pa-cal to write a dark frame calibration.
+func (st *State) darkcalArgs(obj SocketDarkcalObject) ([]string, error) {
+	c := st.calArgs("Dark", obj.socketCommonObject)
+	c.addPath("--outputfile", obj.CalibFileUrl)
+	return c.args, c.err
+}
+
+// Command-line for pa-cal to write a loading calibration.
+func (st *State) loadingcalArgs(obj SocketLoadingcalObject) ([]string, error) {
+	c := st.calArgs("Loading", obj.socketCommonObject)
+	c.addPath("--darkfile", obj.DarkFrameFileUrl)
+	c.addPath("--outputfile", obj.CalibFileUrl)
+	return c.args, c.err
+}
+
+func (st *State) calArgs(cal string, obj socketCommonObject) *cmdline {
+	c := &cmdline{resolver: st.Resolver}
+	c.add("--cal", cal)
+	c.add("--movienum", strconv.Itoa(int(obj.MovieNumber)))
+	c.add("--maxframes", positive(int64(obj.MaxMovieFrames)))
+	c.add("--maxseconds", positive(int64(obj.MaxMovieSeconds)))
+	return c
+}
+
+// Checks that url is the output of a darkcal that completed
+// successfully. A url that no darkcal wrote must be a file that
+// already exists.
+func (st *State) checkDarkcalFile(url string) error {
+	if url == "" {
+		return fmt.Errorf("no dark calibration file given")
+	}
+	for _, id := range st.Sockets.Ids() {
+		s, _ := st.Sockets.Get(id)
+		if s.Darkcal.CalibFileUrl != url {
+			continue
+		}
+		status := s.Darkcal.ProcessStatus
+		if status.ExecutionStatus == Complete && status.CompletionStatus == CompletionSuccess {
+			return nil
+		}
+		return fmt.Errorf("darkcal for %s on socket %s is %s %s", url, id, status.ExecutionStatus, status.CompletionStatus)
+	}
+	path, err := st.Resolver.Path(url)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("dark calibration file: %v", err)
+	}
+	return nil
+}
+
+func joinInts(values []int32) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(s, ",")
+}
+
+// Empty unless v > 0, so unset values are not passed on.
+func positive(v int64) string {
+	if v <= 0 {
+		return ""
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// Only for values that cannot fail to marshal.
+func mustMarshal(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("cannot marshal %T: %v", v, err))
+	}
+	return string(b)
+}
@@ -0,0 +1,109 @@
+package web
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Polls until the app on socket id is COMPLETE.
+func waitApp(t *testing.T, st *State, app socketApp, id string) ProcessStatusObject {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s, _ := st.Sockets.Get(id)
+		if status := *app.status(&s); status.ExecutionStatus == Complete {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s never completed", app.name)
+	return ProcessStatusObject{}
+}
+
+func TestDarkcalThenLoadingcal(t *testing.T) {
+	argsFile := filepath.Join(t.TempDir(), "args")
+	t.Setenv("FAKE_ARGS", argsFile)
+	router, st := newTestState(t, fakeConfig(t))
+	var storage StorageObject
+	decode(t, serve(router, "POST", "/storages", `{"mid": "m1"}`), &storage)
+	dir := strings.TrimPrefix(storage.LinuxPath, "file:")
+
+	darkcal := `{"mid": "m1", "movieNumber": 7, "maxMovieFrames": 512, "calibFileUrl": "http://localhost:23632/storages/m1/darkcal.h5"}`
+	if w := serve(router, "POST", "/sockets/1/darkcal/start", darkcal); w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	if status := waitApp(t, st, darkcalApp, "1"); status.CompletionStatus != CompletionSuccess {
+		t.Fatalf("got %+v", status)
+	}
+	b, _ := os.ReadFile(argsFile)
+	if args := strings.Fields(string(b)); strings.Join(args, " ") != "--cal Dark --movienum 7 --maxframes 512 --outputfile "+dir+"/darkcal.h5" {
+		t.Errorf("got %v", args)
+	}
+
+	loadingcal := `{"mid": "m1", "darkFrameFileUrl": "http://localhost:23632/storages/m1/darkcal.h5", "calibFileUrl": "http://localhost:23632/storages/m1/loadingcal.h5"}`
+	if w := serve(router, "POST", "/sockets/1/loadingcal/start", loadingcal); w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	waitApp(t, st, loadingcalApp, "1")
+	b, _ = os.ReadFile(argsFile)
+	if !strings.Contains(string(b), "--darkfile\n"+dir+"/darkcal.h5\n") {
+		t.Errorf("got %s", b)
+	}
+}
+
+func TestLoadingcalNeedsCompletedDarkcal(t *testing.T) {
+	t.Setenv("FAKE_SLEEP", "10")
+	router, st := newTestState(t, fakeConfig(t))
+	serve(router, "POST", "/storages", `{"mid": "m1"}`)
+
+	loadingcal := `{"darkFrameFileUrl": "http://localhost:23632/storages/m1/darkcal.h5", "calibFileUrl": "http://localhost:23632/storages/m1/loadingcal.h5"}`
+	if w := serve(router, "POST", "/sockets/1/loadingcal/start", loadingcal); w.Code != http.StatusBadRequest {
+		t.Errorf("no darkcal: got %d", w.Code)
+	}
+	serve(router, "POST", "/sockets/1/darkcal/start", `{"calibFileUrl": "http://localhost:23632/storages/m1/darkcal.h5"}`)
+	if w := serve(router, "POST", "/sockets/1/loadingcal/start", loadingcal); w.Code != http.StatusBadRequest {
+		t.Errorf("running darkcal: got %d", w.Code)
+	}
+	serve(router, "POST", "/sockets/1/darkcal/stop", "")
+	waitApp(t, st, darkcalApp, "1")
+	if w := serve(router, "POST", "/sockets/1/loadingcal/start", loadingcal); w.Code != http.StatusBadRequest {
+		t.Errorf("aborted darkcal: got %d", w.Code)
+	}
+}
+
+func TestBasecallerNeedsDarkcalFile(t *testing.T) {
+	router, st := newTestState(t, fakeConfig(t))
+	missing := `{"darkCalFileUrl": "file:` + filepath.Join(t.TempDir(), "darkcal.h5") + `", "expectedFrameRate": 100}`
+	if w := serve(router, "POST", "/sockets/1/basecaller/start", missing); w.Code != http.StatusBadRequest {
+		t.Errorf("got %d", w.Code)
+	}
+
+	path := filepath.Join(t.TempDir(), "darkcal.h5")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	present := `{"darkCalFileUrl": "file://localhost` + path + `", "expectedFrameRate": 100}`
+	if w := serve(router, "POST", "/sockets/1/basecaller/start", present); w.Code != http.StatusOK {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+	waitApp(t, st, basecallerApp, "1")
+}
+
+func TestStartWithUnresolvableUrl(t *testing.T) {
+	router, st := newTestState(t, fakeConfig(t))
+	for _, body := range []string{
+		`{"calibFileUrl": "http://localhost:23632/storages/nonesuch/darkcal.h5"}`,
+		`{"calibFileUrl": "ftp://localhost/darkcal.h5"}`,
+	} {
+		if w := serve(router, "POST", "/sockets/1/darkcal/start", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d", body, w.Code)
+		}
+	}
+	if s, _ := st.Sockets.Get("1"); s.Darkcal.ProcessStatus.ExecutionStatus != Ready {
+		t.Errorf("got %+v", s.Darkcal.ProcessStatus)
+	}
+}
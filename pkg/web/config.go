@@ -0,0 +1,345 @@
+package web
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Config holds the settings pa-ws needs at startup.
+//
+// Each setting has one name, its tag, used as the key in a config file
+// and in GET /config. The same name in words gives its environment
+// variable (socketIds is PAWS_SOCKET_IDS) and its flag (-socket-ids).
+// Durations are written like "30s", lists separated by commas.
+type Config struct {
+	// Address pa-ws listens on, e.g. ":5000"
+	ListenAddress string `yaml:"listenAddress"`
+
+	// Socket identifiers, typically "1" thru "4".
+	SocketIds []string `yaml:"socketIds"`
+
+	// URL of pa-ws as seen by clients, the base of every URL it hands out
+	BaseUrl string `yaml:"baseUrl"`
+
+	// Directories, usually one per partition, under which each movie gets a directory
+	StorageRoots []string `yaml:"storageRoots"`
+
+	// Minimum time between scans of a movie directory. Requests in between get the last scan.
+	StorageScanInterval time.Duration `yaml:"storageScanInterval"`
+
+	// Directory for the logs of pa-ws, and of child processes whose request has no logUrl. Empty means those are not kept.
+	LogDir string `yaml:"logDir"`
+
+	// Size at which the log of a child process is rotated, in MiB
+	LogRotateSizeMb int `yaml:"logRotateSizeMb"`
+
+	// How many rotated logs of each child process are kept
+	LogRotateKeep int `yaml:"logRotateKeep"`
+
+	// Path to the smrt_basecaller executable
+	SmrtBasecaller string `yaml:"smrtBasecaller"`
+
+	// Path to the pa-cal executable, which runs both darkcal and loadingcal
+	PaCal string `yaml:"paCal"`
+
+	// Path to the baz2bam executable
+	Baz2bam string `yaml:"baz2bam"`
+
+	// Path to the ccs executable
+	Ccs string `yaml:"ccs"`
+
+	// How often a running postprimary looks for new output files
+	OutputPollInterval time.Duration `yaml:"outputPollInterval"`
+
+	// How many postprimaries may run at once. The rest wait in a queue.
+	MaxPostprimaries int `yaml:"maxPostprimaries"`
+
+	// Memory that the running postprimaries may use together, in GiB, estimated from the peak RSS of recent ones. Zero means no limit.
+	PostprimaryRssLimitGb float64 `yaml:"postprimaryRssLimitGb"`
+
+	// Signal sent to a child process to stop it gracefully, e.g. "SIGTERM"
+	StopSignal string `yaml:"stopSignal"`
+
+	// How long a child process has to exit after StopSignal, before it gets SIGKILL
+	StopGracePeriod time.Duration `yaml:"stopGracePeriod"`
+
+	// Directory where the state of sockets and postprimaries is kept across restarts. Empty means nowhere.
+	StateDir string `yaml:"stateDir"`
+
+	// Refuse request bodies with fields that the object does not have
+	StrictRequests bool `yaml:"strictRequests"`
+
+	// Where images of the sockets come from: "synthetic", or "pgm:DIR" for DIR/<socketId>.pgm. Empty means nowhere.
+	FrameSource string `yaml:"frameSource"`
+
+	// How many of the latest RT Metrics files of each basecaller GET /sockets/:id/basecaller/rtmetrics returns
+	RtMetricsHistory int `yaml:"rtMetricsHistory"`
+
+	// How many of the latest events GET /events keeps for clients that reconnect with Last-Event-ID
+	EventBufferSize int `yaml:"eventBufferSize"`
+
+	// Key of the HMAC-SHA256 signature of each callback, sent in X-Paws-Signature. Empty means unsigned.
+	WebhookSecret string `yaml:"webhookSecret" secret:"true"`
+
+	// How many times a failed callback is tried again
+	WebhookRetries int `yaml:"webhookRetries"`
+
+	// Wait before the first retry of a callback; it doubles with each retry
+	WebhookBackoff time.Duration `yaml:"webhookBackoff"`
+
+	// How long one attempt of a callback may take
+	WebhookTimeout time.Duration `yaml:"webhookTimeout"`
+
+	// Where GET /docs loads Swagger UI from: a copy of swagger-ui-dist, with swagger-ui.css and swagger-ui-bundle.js
+	SwaggerUiUrl string `yaml:"swaggerUiUrl"`
+
+	// Copy of swagger-ui-dist that pa-ws serves at /swagger-ui, if there is one
+	SwaggerUiDir string `yaml:"swaggerUiDir"`
+}
+
+// DefaultConfig returns the configuration used when nothing else is specified.
+func DefaultConfig() Config {
+	return Config{
+		ListenAddress:       ":5000",
+		SocketIds:           []string{"1", "2", "3", "4"},
+		BaseUrl:             "http://localhost:5000",
+		StorageRoots:        []string{"/data/pa"},
+		StorageScanInterval: 2 * time.Second,
+		LogDir:              "/var/log/pa-ws",
+		LogRotateSizeMb:     100,
+		LogRotateKeep:       5,
+		SmrtBasecaller:      "smrt_basecaller",
+		PaCal:               "pa-cal",
+		Baz2bam:             "baz2bam",
+		Ccs:                 "ccs",
+		OutputPollInterval:  5 * time.Second,
+		MaxPostprimaries:    2,
+		StopSignal:          "SIGTERM",
+		StopGracePeriod:     30 * time.Second,
+		StateDir:            "/var/lib/pa-ws",
+		RtMetricsHistory:    20,
+		EventBufferSize:     1000,
+		WebhookRetries:      5,
+		WebhookBackoff:      time.Second,
+		WebhookTimeout:      10 * time.Second,
+		SwaggerUiUrl:        "/swagger-ui",
+		SwaggerUiDir:        "/usr/share/pa-ws/swagger-ui",
+	}
+}
+
+// LoadConfig returns DefaultConfig, overridden by the YAML (or JSON)
+// file at path, if any. Settings that are not in the file keep their
+// defaults; unknown ones are an error.
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+	if path == "" {
+		return config, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.UnmarshalStrict(b, &config); err != nil {
+		return config, fmt.Errorf("%s: %v", path, err)
+	}
+	return config, nil
+}
+
+// ConfigNames returns the name of every setting, in order.
+func ConfigNames() []string {
+	t := reflect.TypeOf(Config{})
+	names := make([]string, t.NumField())
+	for i := range names {
+		names[i] = t.Field(i).Tag.Get("yaml")
+	}
+	return names
+}
+
+// EnvName is the environment variable for the setting name.
+func EnvName(name string) string {
+	return "PAWS_" + strings.ToUpper(strings.Join(words(name), "_"))
+}
+
+// FlagName is the command-line flag for the setting name.
+func FlagName(name string) string {
+	return strings.Join(words(name), "-")
+}
+
+// Splits a camelCase name into lower case words; "baseUrl" is
+// "base", "url" and "postprimaryRssLimitGb" ends in "gb".
+func words(name string) []string {
+	var ws []string
+	start := 0
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) {
+			ws = append(ws, strings.ToLower(name[start:i]))
+			start = i
+		}
+	}
+	return append(ws, strings.ToLower(name[start:]))
+}
+
+// ApplyEnv sets each setting that has its environment variable set.
+// lookup is usually os.LookupEnv.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	for _, name := range ConfigNames() {
+		if value, ok := lookup(EnvName(name)); ok {
+			if err := c.Set(name, value); err != nil {
+				return fmt.Errorf("%s: %v", EnvName(name), err)
+			}
+		}
+	}
+	return nil
+}
+
+// Set parses value into the setting name.
+func (c *Config) Set(name, value string) error {
+	field, ok := c.field(name)
+	if !ok {
+		return fmt.Errorf("unknown setting %q", name)
+	}
+	switch p := field.Addr().Interface().(type) {
+	case *string:
+		*p = value
+	case *[]string:
+		*p = nil
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				*p = append(*p, s)
+			}
+		}
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*p = d
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*p = n
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*p = b
+	case *float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*p = f
+	default:
+		return fmt.Errorf("setting %q cannot be set from a string", name)
+	}
+	return nil
+}
+
+func (c *Config) field(name string) (reflect.Value, bool) {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("yaml") == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// Validate returns an error listing every setting that pa-ws could not
+// run with, or nil.
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	check(c.ListenAddress != "", "listenAddress is empty")
+	check(len(c.SocketIds) > 0, "socketIds is empty")
+	seen := make(map[string]bool)
+	for _, id := range c.SocketIds {
+		check(id != "" && !strings.ContainsAny(id, "/ "), "socketIds: invalid id %q", id)
+		check(!seen[id], "socketIds: %q is repeated", id)
+		seen[id] = true
+	}
+	u, err := url.Parse(c.BaseUrl)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+		"baseUrl: %q is not an http URL", c.BaseUrl)
+	check(len(c.StorageRoots) > 0, "storageRoots is empty")
+	for _, root := range c.StorageRoots {
+		check(filepath.IsAbs(root), "storageRoots: %q is not absolute", root)
+	}
+	check(c.StorageScanInterval >= 0, "storageScanInterval is negative")
+	check(c.LogDir == "" || filepath.IsAbs(c.LogDir), "logDir: %q is not absolute", c.LogDir)
+	check(c.LogRotateSizeMb > 0, "logRotateSizeMb must be positive")
+	check(c.LogRotateKeep >= 0, "logRotateKeep is negative")
+	for name, path := range map[string]string{
+		"smrtBasecaller": c.SmrtBasecaller,
+		"paCal":          c.PaCal,
+		"baz2bam":        c.Baz2bam,
+		"ccs":            c.Ccs,
+	} {
+		check(path != "", "%s is empty", name)
+	}
+	check(c.OutputPollInterval > 0, "outputPollInterval must be positive")
+	check(c.MaxPostprimaries > 0, "maxPostprimaries must be positive")
+	check(c.PostprimaryRssLimitGb >= 0, "postprimaryRssLimitGb is negative")
+	_, err = ParseSignal(c.StopSignal)
+	check(err == nil, "stopSignal: unknown signal %q", c.StopSignal)
+	check(c.StopGracePeriod >= 0, "stopGracePeriod is negative")
+	check(c.StateDir == "" || filepath.IsAbs(c.StateDir), "stateDir: %q is not absolute", c.StateDir)
+	_, err = newFrameSource(c.FrameSource)
+	check(err == nil, "frameSource: %v", err)
+	check(c.RtMetricsHistory > 0, "rtMetricsHistory must be positive")
+	check(c.EventBufferSize > 0, "eventBufferSize must be positive")
+	check(c.WebhookRetries >= 0, "webhookRetries is negative")
+	check(c.WebhookBackoff > 0, "webhookBackoff must be positive")
+	check(c.WebhookTimeout > 0, "webhookTimeout must be positive")
+	if problems == nil {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+}
+
+// Shown in place of a secret.
+const redacted = "REDACTED"
+
+// Redacted returns the settings by name, as GET /config shows them:
+// durations as strings, and secrets, including passwords in URLs,
+// replaced by REDACTED. Settings tagged secret:"true" are secrets.
+func (c Config) Redacted() map[string]interface{} {
+	v := reflect.ValueOf(c)
+	settings := make(map[string]interface{})
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		value := v.Field(i).Interface()
+		switch x := value.(type) {
+		case time.Duration:
+			value = x.String()
+		case string:
+			if f.Tag.Get("secret") == "true" && x != "" {
+				value = redacted
+			} else if u, err := url.Parse(x); err == nil && u.User != nil {
+				if _, ok := u.User.Password(); ok {
+					u.User = url.UserPassword(u.User.Username(), redacted)
+					value = u.String()
+				}
+			}
+		}
+		settings[f.Tag.Get("yaml")] = value
+	}
+	return settings
+}
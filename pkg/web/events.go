@@ -0,0 +1,164 @@
+package web
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Types of event, as GET /events names them.
+const (
+	EventProcessStatus       = "processStatus"
+	EventPostprimaryProgress = "postprimaryProgress"
+	EventStorageCreated      = "storageCreated"
+	EventStorageDeleted      = "storageDeleted"
+	EventStorageFreed        = "storageFreed"
+
+	// Sent instead of the events a resuming client missed, when they
+	// are no longer buffered. The client should GET what it follows.
+	EventMissed = "missed"
+)
+
+// How many events a subscriber may fall behind before it is dropped.
+// A dropped client reconnects with Last-Event-ID and catches up from
+// the buffer.
+const subscriberBacklog = 64
+
+// EventLog numbers the changes published to it, keeps the latest in a
+// ring buffer and passes each on to every subscriber.
+type EventLog struct {
+	mu     sync.Mutex
+	size   int
+	last   int64 // id of the latest event
+	buffer []EventObject
+	subs   map[chan EventObject]bool
+}
+
+// NewEventLog keeps the latest size events for clients that resume.
+func NewEventLog(size int) *EventLog {
+	if size < 1 {
+		size = 1
+	}
+	return &EventLog{size: size, subs: make(map[chan EventObject]bool)}
+}
+
+// Numbers and timestamps e, and passes it on. Publishing to a nil log
+// does nothing, so registries without one need not check.
+func (l *EventLog) publish(e EventObject) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.last++
+	e.Id = l.last
+	e.Timestamp = timestamp(time.Now())
+	l.buffer = append(l.buffer, e)
+	if len(l.buffer) > l.size {
+		l.buffer = l.buffer[len(l.buffer)-l.size:]
+	}
+	for ch := range l.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(l.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribes to the events after the one with id after, or, unless
+// resume, to those from now on. It returns the buffered events the
+// subscriber missed, and false if some it missed are gone. The channel
+// is closed if the subscriber falls too far behind.
+func (l *EventLog) subscribe(after int64, resume bool) ([]EventObject, chan EventObject, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ch := make(chan EventObject, subscriberBacklog)
+	l.subs[ch] = true
+	if !resume || after == l.last {
+		return nil, ch, true
+	}
+	if after > l.last {
+		// An id from before a restart
+		return nil, ch, false
+	}
+	first := l.last - int64(len(l.buffer)) + 1
+	if after+1 < first {
+		return append([]EventObject{}, l.buffer...), ch, false
+	}
+	return append([]EventObject{}, l.buffer[after+1-first:]...), ch, true
+}
+
+func (l *EventLog) unsubscribe(ch chan EventObject) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.subs[ch] {
+		delete(l.subs, ch)
+		close(ch)
+	}
+}
+
+// Selects the events of one socket, or one movie, or both.
+type eventFilter struct {
+	socketId string
+	mid      string
+}
+
+func (f eventFilter) matches(e EventObject) bool {
+	return (f.socketId == "" || e.SocketId == f.socketId) && (f.mid == "" || e.Mid == f.mid)
+}
+
+// Events for the apps on socket s whose status is not what it was
+// before.
+func socketEvents(apps []socketApp, before []ProcessStatusObject, s *SocketObject) []EventObject {
+	var events []EventObject
+	for i, app := range apps {
+		if status := *app.status(s); status != before[i] {
+			events = append(events, EventObject{
+				Type:          EventProcessStatus,
+				SocketId:      s.SocketId,
+				Mid:           app.common(s).Mid,
+				App:           app.name,
+				ProcessStatus: &status,
+			})
+		}
+	}
+	return events
+}
+
+// The status of each of the apps on socket s.
+func appStatuses(apps []socketApp, s *SocketObject) []ProcessStatusObject {
+	statuses := make([]ProcessStatusObject, len(apps))
+	for i, app := range apps {
+		statuses[i] = *app.status(s)
+	}
+	return statuses
+}
+
+// Events for what changed from before to obj.
+func postprimaryEvents(before, obj PostprimaryObject) []EventObject {
+	var events []EventObject
+	if obj.ProcessStatus != before.ProcessStatus {
+		status := obj.ProcessStatus
+		events = append(events, EventObject{
+			Type:          EventProcessStatus,
+			Mid:           obj.Mid,
+			App:           "postprimary",
+			ProcessStatus: &status,
+		})
+	}
+	if !reflect.DeepEqual(obj.PostprimaryStatus, before.PostprimaryStatus) {
+		progress := obj.PostprimaryStatus
+		events = append(events, EventObject{
+			Type:              EventPostprimaryProgress,
+			Mid:               obj.Mid,
+			PostprimaryStatus: &progress,
+		})
+	}
+	return events
+}
+
+func (st *State) publishStorage(typ string, obj StorageObject) {
+	st.Events.publish(EventObject{Type: typ, Mid: obj.Mid, Storage: &obj})
+}
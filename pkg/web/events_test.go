@@ -0,0 +1,161 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventLogResume(t *testing.T) {
+	l := NewEventLog(3)
+	for i := 0; i < 5; i++ {
+		l.publish(EventObject{Type: EventProcessStatus})
+	}
+	ids := func(events []EventObject) []int64 {
+		var ids []int64
+		for _, e := range events {
+			ids = append(ids, e.Id)
+		}
+		return ids
+	}
+	for _, tc := range []struct {
+		after    int64
+		resume   bool
+		want     []int64
+		complete bool
+	}{
+		{0, false, nil, true},
+		{5, true, nil, true},
+		{3, true, []int64{4, 5}, true},
+		{2, true, []int64{3, 4, 5}, true},
+		{1, true, []int64{3, 4, 5}, false},
+		{9, true, nil, false},
+	} {
+		missed, ch, complete := l.subscribe(tc.after, tc.resume)
+		l.unsubscribe(ch)
+		if got := ids(missed); complete != tc.complete || len(got) != len(tc.want) || (len(got) > 0 && got[0] != tc.want[0]) {
+			t.Errorf("after %d: got %v, %v; want %v, %v", tc.after, got, complete, tc.want, tc.complete)
+		}
+	}
+}
+
+func TestEventLogDropsSlowSubscriber(t *testing.T) {
+	l := NewEventLog(10)
+	_, ch, _ := l.subscribe(0, false)
+	for i := 0; i <= subscriberBacklog; i++ {
+		l.publish(EventObject{Type: EventProcessStatus})
+	}
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != subscriberBacklog {
+		t.Errorf("got %d events before the channel closed", n)
+	}
+	l.unsubscribe(ch)
+}
+
+type sseEvent struct {
+	typ, id string
+	obj     EventObject
+}
+
+// Reads events from GET url until until returns true.
+func readEvents(t *testing.T, url, lastId string, until func(sseEvent) bool) []sseEvent {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if lastId != "" {
+		req.Header.Set("Last-Event-ID", lastId)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("got content type %q", ct)
+	}
+	var events []sseEvent
+	var e sseEvent
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			e.typ = strings.TrimPrefix(line, "event:")
+		case strings.HasPrefix(line, "id:"):
+			e.id = strings.TrimPrefix(line, "id:")
+		case strings.HasPrefix(line, "data:"):
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &e.obj)
+		case line == "" && e.typ != "":
+			events = append(events, e)
+			if until(e) {
+				return events
+			}
+			e = sseEvent{}
+		}
+	}
+	t.Fatalf("stream ended after %+v: %v", events, scanner.Err())
+	return nil
+}
+
+func TestEventsStream(t *testing.T) {
+	router := newTestRouter(t, fakeConfig(t))
+	server := httptest.NewServer(router)
+	defer server.Close()
+	serve(router, "POST", "/storages", `{"mid": "m1"}`)
+	darkcal := `{"mid": "m1", "calibFileUrl": "http://localhost:23632/storages/m1/darkcal.h5"}`
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		serve(router, "POST", "/sockets/2/darkcal/start", darkcal)
+		serve(router, "POST", "/sockets/1/darkcal/start", darkcal)
+	}()
+	events := readEvents(t, server.URL+"/events?socketId=1", "", func(e sseEvent) bool {
+		return e.obj.ProcessStatus != nil && e.obj.ProcessStatus.ExecutionStatus == Complete
+	})
+	for _, e := range events {
+		if e.typ != EventProcessStatus || e.obj.SocketId != "1" || e.obj.App != "darkcal" || e.obj.Mid != "m1" || e.id == "" {
+			t.Errorf("got %+v", e)
+		}
+	}
+	if first := events[0].obj.ProcessStatus.ExecutionStatus; first != Running {
+		t.Errorf("first event is %s", first)
+	}
+}
+
+func TestEventsResume(t *testing.T) {
+	config := testConfig(t)
+	config.EventBufferSize = 2
+	router := newTestRouter(t, config)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	serve(router, "POST", "/storages", `{"mid": "m1"}`)
+	serve(router, "POST", "/storages", `{"mid": "m2"}`)
+	serve(router, "POST", "/storages/m1/free", "")
+
+	last := func(e sseEvent) bool { return e.id == "3" }
+	events := readEvents(t, server.URL+"/events?mid=m1", "1", last)
+	if len(events) != 1 || events[0].typ != EventStorageFreed || events[0].obj.Storage.Mid != "m1" {
+		t.Errorf("after 1, got %+v", events)
+	}
+	events = readEvents(t, server.URL+"/events", "0", last)
+	if len(events) != 3 || events[0].typ != EventMissed || events[1].id != "2" || events[1].typ != EventStorageCreated {
+		t.Errorf("after 0, got %+v", events)
+	}
+
+	req := httptest.NewRequest("GET", "/events", nil)
+	req.Header.Set("Last-Event-ID", "x")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Last-Event-ID x got %d", w.Code)
+	}
+}
@@ -0,0 +1,82 @@
+package web
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Serves a file of the movie, with Range, ETag and Last-Modified
+// support, or lists a directory of it as JSON StorageItemObjects.
+func (st *State) getStorageFile(c *gin.Context) {
+	mid := c.Param("mid")
+	obj, ok := st.Storages.Get(mid)
+	if !ok {
+		storageNotFound(c)
+		return
+	}
+	dir, err := st.Storages.Dir(mid)
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	}
+	rel := path.Clean("/" + c.Param("path"))
+	full, err := containedPath(dir, rel)
+	if os.IsNotExist(err) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "file not found"})
+		return
+	} else if err != nil {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		return
+	}
+	fi, err := os.Stat(full)
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "file not found"})
+		return
+	}
+	if fi.IsDir() {
+		url := strings.TrimSuffix(obj.RootUrl+rel, "/")
+		files, err := scanDir(full, url)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+		c.IndentedJSON(http.StatusOK, files)
+		return
+	}
+
+	f, err := os.Open(full)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	defer f.Close()
+	c.Header("ETag", fmt.Sprintf(`"%x-%x"`, fi.Size(), fi.ModTime().UnixNano()))
+	if ext := filepath.Ext(full); ext == ".bam" || ext == ".baz" || ext == ".h5" {
+		// Binary formats; do not let ServeContent guess from the content.
+		c.Header("Content-Type", "application/octet-stream")
+	}
+	http.ServeContent(c.Writer, c.Request, fi.Name(), fi.ModTime(), f)
+}
+
+// Joins rel onto dir, and checks that the result, with any symlinks
+// followed, is still inside dir.
+func containedPath(dir, rel string) (string, error) {
+	full := filepath.Join(dir, filepath.FromSlash(rel))
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	realFull, err := filepath.EvalSymlinks(full)
+	if err != nil {
+		return "", err
+	}
+	if realFull != realDir && !strings.HasPrefix(realFull, realDir+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the storage of the movie", rel)
+	}
+	return realFull, nil
+}
@@ -0,0 +1,94 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetStorageFile(t *testing.T) {
+	router := newTestRouter(t, testConfig(t))
+	var obj StorageObject
+	decode(t, serve(router, "POST", "/storages", `{"mid": "m1"}`), &obj)
+	dir := strings.TrimPrefix(obj.LinuxPath, "file:")
+	writeFile(t, filepath.Join(dir, "thefile.baz"), "0123456789")
+
+	w := serve(router, "GET", "/storages/m1/thefile.baz", "")
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789" {
+		t.Fatalf("got %d %q", w.Code, w.Body.String())
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" || w.Header().Get("Last-Modified") == "" || w.Header().Get("Content-Length") != "10" {
+		t.Errorf("got headers %v", w.Header())
+	}
+
+	req := httptest.NewRequest("GET", "/storages/m1/thefile.baz", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "234" {
+		t.Errorf("range: got %d %q", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/storages/m1/thefile.baz", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("if-none-match: got %d", w.Code)
+	}
+
+	w = serve(router, "HEAD", "/storages/m1/thefile.baz", "")
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "10" {
+		t.Errorf("head: got %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+
+	if w := serve(router, "GET", "/storages/m1/nonesuch", ""); w.Code != http.StatusNotFound {
+		t.Errorf("missing: got %d", w.Code)
+	}
+	if w := serve(router, "GET", "/storages/m9/thefile.baz", ""); w.Code != http.StatusNotFound {
+		t.Errorf("unknown mid: got %d", w.Code)
+	}
+	if w := serve(router, "GET", "/storages/m1", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"rootUrl"`) {
+		t.Errorf("storage object: got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestListStorageDirectory(t *testing.T) {
+	router := newTestRouter(t, testConfig(t))
+	var obj StorageObject
+	decode(t, serve(router, "POST", "/storages", `{"mid": "m1"}`), &obj)
+	dir := strings.TrimPrefix(obj.LinuxPath, "file:")
+	writeFile(t, filepath.Join(dir, "out", "m1.subreads.bam"), "1")
+
+	var files []StorageItemObject
+	decode(t, serve(router, "GET", "/storages/m1/out", ""), &files)
+	if len(files) != 1 || files[0].Url != obj.RootUrl+"/out/m1.subreads.bam" {
+		t.Errorf("got %+v", files)
+	}
+	decode(t, serve(router, "GET", "/storages/m1/", ""), &files)
+	if len(files) != 1 || files[0].Url != obj.RootUrl+"/out/m1.subreads.bam" {
+		t.Errorf("got %+v", files)
+	}
+}
+
+func TestGetStorageFileOutside(t *testing.T) {
+	router := newTestRouter(t, testConfig(t))
+	var obj StorageObject
+	decode(t, serve(router, "POST", "/storages", `{"mid": "m1"}`), &obj)
+	dir := strings.TrimPrefix(obj.LinuxPath, "file:")
+	outside := filepath.Join(t.TempDir(), "secret")
+	writeFile(t, outside, "x")
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(router, "GET", "/storages/m1/link", ""); w.Code != http.StatusForbidden {
+		t.Errorf("got %d", w.Code)
+	}
+	if w := serve(router, "GET", "/storages/m1/../../etc/passwd", ""); w.Code == http.StatusOK {
+		t.Errorf("got %d", w.Code)
+	}
+}
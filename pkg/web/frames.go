@@ -0,0 +1,215 @@
+package web
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrNoFrameSource is returned for images when no frame source is configured.
+var ErrNoFrameSource = errors.New("no frame source configured")
+
+// Frame is one frame of 16-bit pixels from the sensor of a socket,
+// row by row.
+type Frame struct {
+	Width, Height int
+	Pix           []uint16
+}
+
+func (f *Frame) at(row, col int) uint16 {
+	return f.Pix[row*f.Width+col]
+}
+
+// FrameSource provides the latest frame of a socket. The camera is
+// one; the others stand in for it in tests and on dev machines.
+type FrameSource interface {
+	Frame(socketId string) (*Frame, error)
+}
+
+// Returns the source named by Config.FrameSource:
+//
+//	""             none; every image is an error
+//	"synthetic"    a test pattern, different for each socket
+//	"pgm:DIR"      DIR/<socketId>.pgm, a 16-bit binary PGM
+func newFrameSource(name string) (FrameSource, error) {
+	switch {
+	case name == "":
+		return nil, nil
+	case name == "synthetic":
+		return SyntheticFrames{Width: 256, Height: 128}, nil
+	case strings.HasPrefix(name, "pgm:") && filepath.IsAbs(strings.TrimPrefix(name, "pgm:")):
+		return PgmFrames{Dir: strings.TrimPrefix(name, "pgm:")}, nil
+	}
+	return nil, fmt.Errorf("unknown frame source %q", name)
+}
+
+// SyntheticFrames makes up frames: a diagonal gradient, offset by the
+// socket, so each socket looks different.
+type SyntheticFrames struct {
+	Width, Height int
+}
+
+// Frame returns the test pattern for socketId.
+func (s SyntheticFrames) Frame(socketId string) (*Frame, error) {
+	offset := 0
+	for _, r := range socketId {
+		offset += int(r)
+	}
+	f := &Frame{Width: s.Width, Height: s.Height, Pix: make([]uint16, s.Width*s.Height)}
+	for row := 0; row < s.Height; row++ {
+		for col := 0; col < s.Width; col++ {
+			f.Pix[row*s.Width+col] = uint16((row + col + offset) * 64)
+		}
+	}
+	return f, nil
+}
+
+// PgmFrames reads the frame of each socket from Dir/<socketId>.pgm,
+// a binary ("P5") PGM with a maxval above 255, i.e. 16-bit big-endian
+// pixels.
+type PgmFrames struct {
+	Dir string
+}
+
+// Frame reads the file for socketId.
+func (s PgmFrames) Frame(socketId string) (*Frame, error) {
+	file, err := os.Open(filepath.Join(s.Dir, socketId+".pgm"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	f, err := readPgm(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file.Name(), err)
+	}
+	return f, nil
+}
+
+func readPgm(r *bufio.Reader) (*Frame, error) {
+	var magic string
+	var width, height, maxval int
+	if _, err := fmt.Fscan(r, &magic, &width, &height, &maxval); err != nil {
+		return nil, err
+	}
+	if magic != "P5" || maxval < 256 || maxval > 65535 || width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("not a 16-bit binary PGM")
+	}
+	// A single whitespace separates the header from the pixels.
+	if _, err := r.ReadByte(); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 2*width*height)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	f := &Frame{Width: width, Height: height, Pix: make([]uint16, width*height)}
+	for i := range f.Pix {
+		f.Pix[i] = uint16(buf[2*i])<<8 | uint16(buf[2*i+1])
+	}
+	return f, nil
+}
+
+// What GET /sockets/:id/image asked for.
+type imageQuery struct {
+	format     string
+	roi        []int // nil for the whole frame
+	downsample int
+}
+
+func parseImageQuery(format, roi, downsample string) (imageQuery, error) {
+	var v validator
+	q := imageQuery{format: format}
+	v.check(format == "png" || format == "raw", "format", "must be png or raw, not %q", format)
+	if roi != "" {
+		for _, s := range strings.Split(roi, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(s))
+			v.check(err == nil, "roi", "%q is not an integer", s)
+			q.roi = append(q.roi, n)
+		}
+		v.check(len(q.roi) == 4, "roi", "must be 4 integers [row, col, rows, cols], not %d", len(q.roi))
+	}
+	n, err := strconv.Atoi(downsample)
+	v.check(err == nil && n > 0, "downsample", "must be a positive integer, not %q", downsample)
+	q.downsample = n
+	return q, v.err()
+}
+
+// Returns the ROI of the query within f, checking that it fits.
+func (q imageQuery) fit(f *Frame) ([4]int, error) {
+	roi := [4]int{0, 0, f.Height, f.Width}
+	if q.roi != nil {
+		copy(roi[:], q.roi)
+	}
+	var v validator
+	// Sizes are checked against what is left of the frame, as origin
+	// plus size could overflow.
+	v.check(roi[0] >= 0 && roi[1] >= 0 && roi[2] <= f.Height-roi[0] && roi[3] <= f.Width-roi[1],
+		"roi", "%v is not within the %d by %d frame", roi, f.Height, f.Width)
+	v.check(roi[2] >= q.downsample && roi[3] >= q.downsample,
+		"downsample", "%d is more than the %d by %d roi", q.downsample, roi[2], roi[3])
+	return roi, v.err()
+}
+
+// Returns the part of f in roi, [row, col, rows, cols], averaged over
+// blocks of n by n pixels. Partial blocks at the edges are dropped.
+// The caller has checked that roi is within f and n is positive.
+func (f *Frame) crop(roi [4]int, n int) *Frame {
+	out := &Frame{Width: roi[3] / n, Height: roi[2] / n}
+	out.Pix = make([]uint16, out.Width*out.Height)
+	for row := 0; row < out.Height; row++ {
+		for col := 0; col < out.Width; col++ {
+			sum := 0
+			for i := 0; i < n; i++ {
+				for j := 0; j < n; j++ {
+					sum += int(f.at(roi[0]+row*n+i, roi[1]+col*n+j))
+				}
+			}
+			out.Pix[row*out.Width+col] = uint16(sum / (n * n))
+		}
+	}
+	return out
+}
+
+// An 8-bit image of f, scaled so that its darkest pixel is black and
+// its brightest white.
+func (f *Frame) image() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, f.Width, f.Height))
+	if len(f.Pix) == 0 {
+		return img
+	}
+	lo, hi := f.Pix[0], f.Pix[0]
+	for _, p := range f.Pix {
+		if p < lo {
+			lo = p
+		}
+		if p > hi {
+			hi = p
+		}
+	}
+	span := int(hi) - int(lo)
+	for i, p := range f.Pix {
+		var v uint8
+		if span > 0 {
+			v = uint8((int(p) - int(lo)) * 255 / span)
+		}
+		img.SetGray(i%f.Width, i/f.Width, color.Gray{Y: v})
+	}
+	return img
+}
+
+// The pixels of f, little-endian.
+func (f *Frame) raw() []byte {
+	b := make([]byte, 2*len(f.Pix))
+	for i, p := range f.Pix {
+		b[2*i] = byte(p)
+		b[2*i+1] = byte(p >> 8)
+	}
+	return b
+}
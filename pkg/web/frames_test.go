@@ -0,0 +1,119 @@
+package web
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Writes a 16-bit PGM of a width by height frame whose pixels are
+// numbered from 0, row by row.
+func writePgm(t *testing.T, path string, width, height int) {
+	t.Helper()
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "P5\n%d %d\n65535\n", width, height)
+	for i := 0; i < width*height; i++ {
+		binary.Write(&buf, binary.BigEndian, uint16(i))
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCropFrame(t *testing.T) {
+	f := &Frame{Width: 4, Height: 4}
+	for i := 0; i < 16; i++ {
+		f.Pix = append(f.Pix, uint16(i))
+	}
+	got := f.crop([4]int{1, 0, 3, 4}, 2)
+	// The blocks (4,5,8,9) and (6,7,10,11); row 3 is a partial block.
+	want := &Frame{Width: 2, Height: 1, Pix: []uint16{6, 8}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestGetRawImage(t *testing.T) {
+	dir := t.TempDir()
+	writePgm(t, filepath.Join(dir, "1.pgm"), 8, 6)
+	config := testConfig(t)
+	config.FrameSource = "pgm:" + dir
+	router := newTestRouter(t, config)
+
+	w := serve(router, "GET", "/sockets/1/image?format=raw&roi=2,4,2,3", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	if h := w.Header(); h.Get("Content-Type") != "application/octet-stream" ||
+		h.Get("X-Frame-Width") != "3" || h.Get("X-Frame-Height") != "2" || h.Get("X-Frame-Dtype") != "uint16le" {
+		t.Errorf("got %v", h)
+	}
+	pix := make([]uint16, 6)
+	binary.Read(w.Body, binary.LittleEndian, pix)
+	if want := []uint16{20, 21, 22, 28, 29, 30}; !reflect.DeepEqual(pix, want) {
+		t.Errorf("got %v", pix)
+	}
+
+	if w := serve(router, "GET", "/sockets/2/image", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("no file: got %d", w.Code)
+	}
+}
+
+func TestGetPngImage(t *testing.T) {
+	config := testConfig(t)
+	config.FrameSource = "synthetic"
+	router := newTestRouter(t, config)
+
+	w := serve(router, "GET", "/sockets/3/image?downsample=4", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("got %d %v", w.Code, w.Header())
+	}
+	img, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 64 || b.Dy() != 32 {
+		t.Errorf("got %v", b)
+	}
+	// Scaled to the full range.
+	if lo, _, _, _ := img.At(0, 0).RGBA(); lo != 0 {
+		t.Errorf("darkest %d", lo)
+	}
+	if hi, _, _, _ := img.At(63, 31).RGBA(); hi != 0xffff {
+		t.Errorf("brightest %d", hi)
+	}
+}
+
+func TestGetImageBadRequest(t *testing.T) {
+	config := testConfig(t)
+	config.FrameSource = "synthetic"
+	router := newTestRouter(t, config)
+	for _, query := range []string{
+		"format=jpeg",
+		"roi=0,0,10",
+		"roi=0,0,a,10",
+		"roi=100,0,100,10",
+		"roi=1,0,9223372036854775807,1",
+		"roi=0,1,1,9223372036854775807",
+		"downsample=0",
+		"roi=0,0,2,2&downsample=3",
+	} {
+		if w := serve(router, "GET", "/sockets/1/image?"+query, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d", query, w.Code)
+		}
+	}
+	if w := serve(router, "GET", "/sockets/9/image", ""); w.Code != http.StatusNotFound {
+		t.Errorf("got %d", w.Code)
+	}
+
+	router = newTestRouter(t, testConfig(t))
+	if w := serve(router, "GET", "/sockets/1/image", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("no frame source: got %d", w.Code)
+	}
+}
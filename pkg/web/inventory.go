@@ -0,0 +1,114 @@
+package web
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Categories of StorageItemObject, by file name suffix. Longer suffixes
+// come first, so that e.g. ".trc.h5" is not taken for a calibration.
+var categories = []struct {
+	suffix, category string
+}{
+	{".subreads.bam", "BAM"},
+	{".bam.pbi", "BAM"},
+	{".bam", "BAM"},
+	{".baz", "BAZ"},
+	{".trc.h5", "TRC"},
+	{".sts.h5", "STATS"},
+	{".rsts.h5", "STATS"},
+	{".stats.xml", "STATS"},
+	{"cal.h5", "CAL"},
+	{".h5", "CAL"},
+	{".xml", "XML"},
+	{".log", "LOG"},
+}
+
+// The Category of a StorageItemObject, inferred from its file name.
+func fileCategory(name string) string {
+	name = strings.ToLower(name)
+	for _, c := range categories {
+		if strings.HasSuffix(name, c.suffix) {
+			return c.category
+		}
+	}
+	return "UNKNOWN"
+}
+
+// inventory caches directory scans for a while, so that clients polling
+// a StorageObject during a run do not walk the movie directory each time.
+type inventory struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	scans map[string]scan
+}
+
+type scan struct {
+	at    time.Time
+	files []StorageItemObject
+}
+
+func newInventory(ttl time.Duration) *inventory {
+	return &inventory{ttl: ttl, scans: make(map[string]scan)}
+}
+
+// Files under dir, as items with URLs under rootUrl.
+func (inv *inventory) files(dir, rootUrl string) ([]StorageItemObject, error) {
+	inv.mu.Lock()
+	cached, ok := inv.scans[dir]
+	inv.mu.Unlock()
+	if ok && time.Since(cached.at) < inv.ttl {
+		return cached.files, nil
+	}
+
+	now := time.Now()
+	files, err := scanDir(dir, rootUrl)
+	if err != nil {
+		return nil, err
+	}
+	inv.mu.Lock()
+	inv.scans[dir] = scan{at: now, files: files}
+	inv.mu.Unlock()
+	return files, nil
+}
+
+// Drops the cached scan of dir, e.g. once it is freed.
+func (inv *inventory) forget(dir string) {
+	inv.mu.Lock()
+	delete(inv.scans, dir)
+	inv.mu.Unlock()
+}
+
+func scanDir(dir, rootUrl string) ([]StorageItemObject, error) {
+	files := []StorageItemObject{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			// Removed since the directory was read.
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, StorageItemObject{
+			Url:       rootUrl + "/" + path.Clean(filepath.ToSlash(rel)),
+			Timestamp: timestamp(info.ModTime()),
+			Size:      info.Size(),
+			Category:  fileCategory(info.Name()),
+		})
+		return nil
+	})
+	return files, err
+}
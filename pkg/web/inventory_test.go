@@ -0,0 +1,71 @@
+package web
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileCategory(t *testing.T) {
+	tests := map[string]string{
+		"m1.subreads.bam":     "BAM",
+		"m1.subreads.bam.pbi": "BAM",
+		"thefile.baz":         "BAZ",
+		"darkcal.h5":          "CAL",
+		"m1.trc.h5":           "TRC",
+		"m1.sts.h5":           "STATS",
+		"rtmetrics_1.xml":     "XML",
+		"storage.log":         "LOG",
+		"core":                "UNKNOWN",
+	}
+	for name, want := range tests {
+		if got := fileCategory(name); got != want {
+			t.Errorf("%s: got %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestStorageReport(t *testing.T) {
+	config := testConfig(t)
+	config.StorageScanInterval = time.Hour
+	router := newTestRouter(t, config)
+	var obj StorageObject
+	decode(t, serve(router, "POST", "/storages", `{"mid": "m1"}`), &obj)
+	dir := strings.TrimPrefix(obj.LinuxPath, "file:")
+	writeFile(t, filepath.Join(dir, "thefile.baz"), "12345")
+	writeFile(t, filepath.Join(dir, "out", "m1.subreads.bam"), "1")
+
+	decode(t, serve(router, "GET", "/storages/m1", ""), &obj)
+	if len(obj.Files) != 2 {
+		t.Fatalf("got %+v", obj.Files)
+	}
+	bam, baz := obj.Files[0], obj.Files[1]
+	if bam.Url != obj.RootUrl+"/out/m1.subreads.bam" || bam.Category != "BAM" || bam.Size != 1 {
+		t.Errorf("got %+v", bam)
+	}
+	if baz.Url != obj.RootUrl+"/thefile.baz" || baz.Category != "BAZ" || baz.Size != 5 {
+		t.Errorf("got %+v", baz)
+	}
+	if len(obj.Space) != 1 || obj.Space[0].TotalSpace <= 0 || obj.Space[0].FreeSpace > obj.Space[0].TotalSpace {
+		t.Errorf("got %+v", obj.Space)
+	}
+
+	// Cached, so a new file does not show up yet.
+	writeFile(t, filepath.Join(dir, "darkcal.h5"), "")
+	decode(t, serve(router, "GET", "/storages/m1", ""), &obj)
+	if len(obj.Files) != 2 {
+		t.Errorf("scanned again: %+v", obj.Files)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
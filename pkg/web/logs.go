@@ -0,0 +1,598 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"pacb.com/seq/paws/pkg/resolver"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// UnsafeLogError is returned for a log that pa-ws will not write or
+// serve, so that a logUrl can neither clobber nor expose other files.
+type UnsafeLogError struct {
+	Path   string
+	Reason string
+}
+
+func (e *UnsafeLogError) Error() string {
+	return "refusing to log to " + e.Path + ": " + e.Reason
+}
+
+// The level of a line of output is the first level named in it, e.g.
+//
+//	2021-06-25 12:34:56.789 | WARN | Low SNR on analog C
+//	[ERROR] cannot open /data/pa/m1.baz
+//
+// Lines that name none, like the rest of a stack trace, are taken to be
+// at the level of the line before.
+var logLevelPattern = regexp.MustCompile(`\b(DEBUG|INFO|WARN|WARNING|ERROR|FATAL|CRITICAL)\b`)
+
+func levelRank(level string) int {
+	switch level {
+	case "DEBUG":
+		return 0
+	case "", "INFO":
+		return 1
+	case "WARN", "WARNING":
+		return 2
+	}
+	return 3
+}
+
+// logFile is the log of a child process. The child writes its stdout
+// and stderr to the file itself, rather than through a pipe to pa-ws,
+// so that it carries on when pa-ws restarts. The level is therefore
+// applied as the log is rotated, trimmed and read, not as it is written.
+//
+// Polling the log hands what the child wrote to follow, if set, and
+// rotates the log once the child has written more than maxSize to it:
+// the lines at or above the level are copied to log.1 (log.1 having
+// become log.2 and so on, keeping the latest keep of them), and the log
+// is truncated. The child appends, so it carries on from there; what it
+// writes between the copy and the truncation is lost. What the log held
+// before it was opened is never rotated, trimmed or truncated.
+//
+// The methods of a nil *logFile do nothing, for a child without a log.
+type logFile struct {
+	path    string
+	level   LogLevelEnum
+	maxSize int64
+	keep    int
+
+	// Gets what the child writes, if set
+	follow io.Writer
+
+	mu     sync.Mutex
+	file   *os.File // nil once closed
+	offset int64    // of what follow has yet to get
+	start  int64    // of what has yet to be trimmed to the level
+}
+
+// Creates the log at path for a new run, and its directory as need be.
+// An empty level is INFO. The log must be a new file, so that a logUrl
+// cannot name one to clobber, unless own: a log of pa-ws's own, in
+// Config.LogDir, that a run before left is moved aside to log.1 first.
+func createLog(path string, level LogLevelEnum, maxSize int64, keep int, own bool) (*logFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if own && isRegular(path) {
+		shiftRotated(path, keep)
+		if keep > 0 {
+			renameRegular(path, rotated(path, 1))
+		} else {
+			removeRegular(path)
+		}
+	}
+	return openLog(path, os.O_CREATE|os.O_EXCL, level, maxSize, keep)
+}
+
+// Opens the log at path of a child that an earlier pa-ws started, to go
+// on from where it is.
+func reopenLog(path string, level LogLevelEnum, maxSize int64, keep int) (*logFile, error) {
+	return openLog(path, os.O_CREATE, level, maxSize, keep)
+}
+
+// Opens the log at path for appending, with flag besides. The log must
+// be a regular file: not a symlink, and not a device like /dev/null
+// that rotation would truncate or rename away.
+func openLog(path string, flag int, level LogLevelEnum, maxSize int64, keep int) (*logFile, error) {
+	f, err := os.OpenFile(path, flag|os.O_RDWR|os.O_APPEND|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, &UnsafeLogError{path, "not a regular file"}
+	}
+	return &logFile{
+		path:    path,
+		level:   level,
+		maxSize: maxSize,
+		keep:    keep,
+		file:    f,
+		offset:  info.Size(),
+		start:   info.Size(),
+	}, nil
+}
+
+// The file for the stdout and stderr of the child, or nil for none.
+func (l *logFile) output() *os.File {
+	if l == nil {
+		return nil
+	}
+	return l.file
+}
+
+// Hands what the child wrote since the last poll to follow, then
+// rotates the log if the child has written more than maxSize to it.
+func (l *logFile) poll() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	size, err := l.read()
+	if err != nil {
+		return err
+	}
+	if size-l.start > l.maxSize {
+		return l.rotate(size)
+	}
+	return nil
+}
+
+// Hands follow what it has yet to get, and returns the size of the
+// log. The caller holds the lock.
+func (l *logFile) read() (int64, error) {
+	info, err := l.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	if size < l.offset {
+		// Truncated by someone else
+		l.offset, l.start = size, size
+	}
+	if l.follow != nil && size > l.offset {
+		if _, err := io.Copy(l.follow, io.NewSectionReader(l.file, l.offset, size-l.offset)); err != nil {
+			return size, err
+		}
+	}
+	l.offset = size
+	return size, nil
+}
+
+// Only regular files are rotated; if anything else is in the way, the
+// log is left to grow. The caller holds the lock.
+func (l *logFile) rotate(size int64) error {
+	if l.keep > 0 {
+		shiftRotated(l.path, l.keep)
+		f, err := os.OpenFile(rotated(l.path, 1), os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, 0644)
+		if err != nil {
+			return err
+		}
+		err = filterLog(io.NewSectionReader(l.file, l.start, size-l.start), f, l.level)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := l.file.Truncate(l.start); err != nil {
+		return err
+	}
+	l.offset = l.start
+	return nil
+}
+
+// Drops the lines below the level from what the child wrote since
+// start. The caller holds the lock.
+func (l *logFile) trim(size int64) error {
+	if size <= l.start {
+		return nil
+	}
+	var kept bytes.Buffer
+	if err := filterLog(io.NewSectionReader(l.file, l.start, size-l.start), &kept, l.level); err != nil {
+		return err
+	}
+	if int64(kept.Len()) == size-l.start {
+		return nil
+	}
+	if err := l.file.Truncate(l.start); err != nil {
+		return err
+	}
+	_, err := l.file.Write(kept.Bytes())
+	return err
+}
+
+// Close hands follow the rest of the output, trims the log to its
+// level and closes it. It is for once the child has exited, so that
+// nothing writes to the log meanwhile.
+func (l *logFile) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	size, err := l.read()
+	if err == nil {
+		err = l.trim(size)
+	}
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	l.file = nil
+	return err
+}
+
+// Closes the log as it is, for a child that outlives pa-ws.
+func (l *logFile) release() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// Wraps the onChange of the child, to close the log before the child
+// is reported COMPLETE, and so before it can be started again.
+func (l *logFile) closing(onChange func(ProcessStatusObject)) func(ProcessStatusObject) {
+	if l == nil {
+		return onChange
+	}
+	return func(status ProcessStatusObject) {
+		if status.ExecutionStatus == Complete {
+			l.Close()
+		}
+		onChange(status)
+	}
+}
+
+// Keeps the level of the lines of a log, to drop those below min.
+type levelFilter struct {
+	min  int
+	last int // level of the latest line that named one
+}
+
+func newLevelFilter(level LogLevelEnum) *levelFilter {
+	return &levelFilter{min: levelRank(string(level)), last: levelRank(Info)}
+}
+
+func (f *levelFilter) keep(line []byte) bool {
+	if m := logLevelPattern.FindSubmatch(line); m != nil {
+		f.last = levelRank(string(m[1]))
+	}
+	return f.last >= f.min
+}
+
+// Copies the lines of r at or above level to w.
+func filterLog(r io.Reader, w io.Writer, level LogLevelEnum) error {
+	filter := newLevelFilter(level)
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 && filter.keep(line) {
+			if _, werr := w.Write(line); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func rotated(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// Makes room for log.1: log.1 becomes log.2 and so on, and the one past
+// keep goes.
+func shiftRotated(path string, keep int) {
+	if keep < 1 {
+		return
+	}
+	removeRegular(rotated(path, keep))
+	for i := keep - 1; i >= 1; i-- {
+		renameRegular(rotated(path, i), rotated(path, i+1))
+	}
+}
+
+func isRegular(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+func removeRegular(path string) {
+	if isRegular(path) {
+		os.Remove(path)
+	}
+}
+
+func renameRegular(from, to string) {
+	if isRegular(from) {
+		if _, err := os.Lstat(to); os.IsNotExist(err) || isRegular(to) {
+			os.Rename(from, to)
+		}
+	}
+}
+
+// Local path of the log for logUrl, or, without one, for fallback
+// under Config.LogDir. It is empty if no log is kept: for a discard:
+// logUrl, or for neither.
+func (st *State) logPath(logUrl, fallback string) (string, error) {
+	if resolver.IsDiscard(logUrl) {
+		return "", nil
+	}
+	if logUrl == "" {
+		if st.Config.LogDir == "" {
+			return "", nil
+		}
+		return filepath.Join(st.Config.LogDir, fallback), nil
+	}
+	path, err := st.Resolver.Path(logUrl)
+	if err != nil {
+		return "", err
+	}
+	return path, st.checkLogPath(path)
+}
+
+// The log of a logUrl must be in a storage root or in Config.LogDir,
+// be a regular file if it exists, and not be a file of pa-ws itself.
+func (st *State) checkLogPath(path string) error {
+	if info, err := os.Lstat(path); err == nil && !info.Mode().IsRegular() {
+		return &UnsafeLogError{path, "not a regular file"}
+	}
+	dir, err := realDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	real := filepath.Join(dir, filepath.Base(path))
+	for _, own := range st.ownFiles() {
+		if ownDir, err := realDir(filepath.Dir(own)); err == nil && real == filepath.Join(ownDir, filepath.Base(own)) {
+			return &UnsafeLogError{path, "a file of pa-ws itself"}
+		}
+	}
+	for _, root := range append([]string{st.Config.LogDir}, st.Config.StorageRoots...) {
+		if root == "" {
+			continue
+		}
+		if real, err := realDir(root); err == nil && within(dir, real) {
+			return nil
+		}
+	}
+	return &UnsafeLogError{path, "not in a storage root or the log directory"}
+}
+
+// The files that pa-ws keeps its own state in.
+func (st *State) ownFiles() []string {
+	files := st.Storages.ownFiles()
+	if st.Store != nil {
+		files = append(files, st.Store.path, st.Store.path+".tmp")
+	}
+	return files
+}
+
+// Checks the logUrl of a start. Its log must be a new file, with no
+// rotated logs in the way either, so that it clobbers nothing.
+func (st *State) checkLogUrl(logUrl string) error {
+	if logUrl == "" || resolver.IsDiscard(logUrl) {
+		return nil
+	}
+	path, err := st.Resolver.Path(logUrl)
+	if err != nil {
+		return err
+	}
+	if err := st.checkLogPath(path); err != nil {
+		return err
+	}
+	for i := 0; i <= st.Config.LogRotateKeep; i++ {
+		name := path
+		if i > 0 {
+			name = rotated(path, i)
+		}
+		if _, err := os.Lstat(name); err == nil {
+			return &UnsafeLogError{path, name + " already exists"}
+		}
+	}
+	return nil
+}
+
+// Resolves the symlinks of dir, as far as it exists.
+func realDir(dir string) (string, error) {
+	real, err := filepath.EvalSymlinks(dir)
+	if os.IsNotExist(err) && filepath.Dir(dir) != dir {
+		parent, err := realDir(filepath.Dir(dir))
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(parent, filepath.Base(dir)), nil
+	}
+	return real, err
+}
+
+// Whether path is dir or below it.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Creates the log at path for a new run, with the configured rotation;
+// own is as for createLog. It returns nil if path is empty, or if the
+// log cannot be created: a logging problem must not stop a movie, so
+// the child then runs without a log, and the problem is only logged.
+func (st *State) newLog(path string, level LogLevelEnum, own bool) *logFile {
+	if path == "" {
+		return nil
+	}
+	l, err := createLog(path, level, int64(st.Config.LogRotateSizeMb)<<20, st.Config.LogRotateKeep, own)
+	return logOpened(l, err)
+}
+
+// Reopens the log at path of an adopted child, or returns nil, as
+// newLog does.
+func (st *State) reopenLog(path string, level LogLevelEnum) *logFile {
+	if path == "" {
+		return nil
+	}
+	l, err := reopenLog(path, level, int64(st.Config.LogRotateSizeMb)<<20, st.Config.LogRotateKeep)
+	return logOpened(l, err)
+}
+
+func logOpened(l *logFile, err error) *logFile {
+	if err != nil {
+		log.Printf("log: %v", err)
+		return nil
+	}
+	return l
+}
+
+// Opens the log of the app on socket s, for a new run or, if adopted,
+// for the child an earlier pa-ws started. It returns nil as newLog does.
+func (st *State) socketLog(app socketApp, s *SocketObject, adopted bool) *logFile {
+	path, err := st.socketLogPath(app, s)
+	if err != nil {
+		log.Printf("log: %v", err)
+		return nil
+	}
+	if adopted {
+		return st.reopenLog(path, app.common(s).LogLevel)
+	}
+	return st.newLog(path, app.common(s).LogLevel, app.common(s).LogUrl == "")
+}
+
+// Reopens the log of the adopted postprimary for obj, or returns nil,
+// as newLog does.
+func (st *State) postprimaryLog(obj PostprimaryObject) *logFile {
+	path, err := st.postprimaryLogPath(obj)
+	if err != nil {
+		log.Printf("log: %v", err)
+		return nil
+	}
+	return st.reopenLog(path, obj.LogLevel)
+}
+
+// Polls the log of p, to rotate it, until p exits, or until pa-ws
+// closes and leaves the log to the next one. A nil p has exited.
+func (st *State) pollLog(l *logFile, p *Process) {
+	if l == nil || p == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(st.Config.OutputPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				l.poll()
+			case <-p.Done():
+				return
+			case <-st.done:
+				l.release()
+				return
+			}
+		}
+	}()
+}
+
+// Path of the log of the app on socket s.
+func (st *State) socketLogPath(app socketApp, s *SocketObject) (string, error) {
+	return st.logPath(app.common(s).LogUrl, filepath.Join("sockets", s.SocketId, app.name+".log"))
+}
+
+// Path of the log of the postprimary for obj.
+func (st *State) postprimaryLogPath(obj PostprimaryObject) (string, error) {
+	return st.logPath(obj.LogUrl, filepath.Join("postprimaries", obj.Mid+".log"))
+}
+
+// Returns the last n lines at or above level of the log at path,
+// reading on into the latest rotated file if the log itself is shorter.
+func tailLog(path string, n int, level LogLevelEnum) ([]string, error) {
+	lines, err := tailFile(path, n, level)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) < n {
+		older, err := tailFile(rotated(path, 1), n-len(lines), level)
+		if err == nil {
+			lines = append(older, lines...)
+		}
+	}
+	return lines, nil
+}
+
+// Reads backwards from the end of the file, a growing chunk at a time,
+// until it has n lines at or above level, or the whole file.
+func tailFile(path string, n int, level LogLevelEnum) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	for chunk := int64(64 << 10); ; chunk *= 4 {
+		offset := size - chunk
+		if offset < 0 {
+			offset = 0
+		}
+		buf := make([]byte, size-offset)
+		if _, err := f.ReadAt(buf, offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		text := strings.TrimSuffix(string(buf), "\n")
+		lines := strings.Split(text, "\n")
+		if offset > 0 {
+			// The first line may be cut off.
+			lines = lines[1:]
+		}
+		filter := newLevelFilter(level)
+		kept := lines[:0]
+		for _, line := range lines {
+			if filter.keep([]byte(line)) {
+				kept = append(kept, line)
+			}
+		}
+		lines = kept
+		if len(lines) >= n || offset == 0 {
+			if text == "" {
+				lines = nil
+			}
+			if len(lines) > n {
+				lines = lines[len(lines)-n:]
+			}
+			return lines, nil
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package web
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLogLevels(t *testing.T) {
+	output := "2021-06-25 12:34:56.789 | DEBUG | frame 1\n" +
+		"2021-06-25 12:34:56.790 | INFO | started\n" +
+		"no level, so INFO too\n" +
+		"[WARN] low SNR\n" +
+		"ERROR: cannot open m1.baz\n" +
+		"  at line 2 of the trace\n" +
+		"INFO done, without a newline"
+	for level, want := range map[LogLevelEnum]int{Debug: 7, "": 6, Info: 6, Warn: 3, Error: 2} {
+		path := filepath.Join(t.TempDir(), "app.log")
+		earlier := "ERROR from an earlier run\n"
+		os.WriteFile(path, []byte(earlier), 0644)
+		l, err := reopenLog(path, level, 1<<20, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// As the child would, polled along the way
+		for _, piece := range []string{output[:30], output[30:100], output[100:]} {
+			l.output().WriteString(piece)
+			l.poll()
+		}
+		if lines, _ := tailLog(path, 100, level); len(lines) != want+1 {
+			t.Errorf("%q: tail got %d lines: %q", level, len(lines), lines)
+		}
+		// Trimmed to the level, leaving the earlier run be
+		l.Close()
+		b, _ := os.ReadFile(path)
+		run := strings.TrimPrefix(string(b), earlier)
+		if lines := strings.Split(strings.TrimSuffix(run, "\n"), "\n"); run == string(b) || len(lines) != want {
+			t.Errorf("%q: log is %q", level, b)
+		}
+	}
+}
+
+func TestLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	var followed strings.Builder
+	l, err := createLog(path, Info, 20, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.follow = &followed
+	for _, line := range []string{"line 1", "DEBUG 2", "INFO 3", "line 4", "line 5", "line 6", "line 7"} {
+		l.output().WriteString(line + "\n")
+		l.poll()
+	}
+	l.Close()
+	for name, want := range map[string]string{
+		path:        "line 7\n",
+		path + ".1": "line 4\nline 5\nline 6\n",
+		path + ".2": "line 1\nINFO 3\n",
+	} {
+		if b, _ := os.ReadFile(name); string(b) != want {
+			t.Errorf("%s: got %q, want %q", name, b, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("kept more than 2 rotated logs")
+	}
+	lines, _ := tailLog(path, 3, Info)
+	if want := []string{"line 5", "line 6", "line 7"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("tail got %q, want %q", lines, want)
+	}
+	if want := "line 1\nDEBUG 2\nINFO 3\nline 4\nline 5\nline 6\nline 7\n"; followed.String() != want {
+		t.Errorf("followed %q", followed.String())
+	}
+
+	// A next run starts a new log: at a logUrl, only where there is
+	// none; in the log directory, moving the last one aside.
+	if _, err := createLog(path, Info, 20, 2, false); err == nil {
+		t.Error("created a log over an existing file")
+	}
+	l, err = createLog(path, Info, 20, 2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	for name, want := range map[string]string{
+		path:        "",
+		path + ".1": "line 7\n",
+		path + ".2": "line 4\nline 5\nline 6\n",
+	} {
+		if b, _ := os.ReadFile(name); string(b) != want {
+			t.Errorf("next run: %s: got %q, want %q", name, b, want)
+		}
+	}
+}
+
+// Rotation copies and truncates only what the child wrote, whatever
+// was in the file before.
+func TestLogRotationKeepsWhatWasThere(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	before := strings.Repeat("earlier\n", 100)
+	os.WriteFile(path, []byte(before), 0644)
+	l, err := reopenLog(path, Info, 20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"line 1", "line 2", "line 3", "line 4"} {
+		l.output().WriteString(line + "\n")
+		l.poll()
+	}
+	l.Close()
+	if b, _ := os.ReadFile(path); string(b) != before+"line 4\n" {
+		t.Errorf("log is %q", b)
+	}
+	if b, _ := os.ReadFile(path + ".1"); string(b) != "line 1\nline 2\nline 3\n" {
+		t.Errorf("rotated log is %q", b)
+	}
+}
+
+func TestLogRotationOnlyMovesRegularFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	other := filepath.Join(dir, "other")
+	os.WriteFile(other, []byte("not a log\n"), 0644)
+	os.Symlink(other, path+".1")
+	l, err := createLog(path, Info, 7, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.output().WriteString("line 1\nline 2\n")
+	if err := l.poll(); err == nil {
+		t.Error("rotated onto a symlink")
+	}
+	l.Close()
+	if b, _ := os.ReadFile(other); string(b) != "not a log\n" {
+		t.Errorf("rotation went through the symlink: %q", b)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "line 1\nline 2\n" {
+		t.Errorf("log is %q, though it could not be rotated", b)
+	}
+
+	os.Symlink(other, filepath.Join(dir, "link.log"))
+	if _, err := reopenLog(filepath.Join(dir, "link.log"), Info, 7, 1); err == nil {
+		t.Error("opened a symlink as a log")
+	}
+	if _, err := reopenLog(os.DevNull, Info, 7, 1); err == nil {
+		t.Error("opened a device as a log")
+	}
+}
+
+func TestTailFileLongerThanChunk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	line := strings.Repeat("x", 1000)
+	os.WriteFile(path, []byte(strings.Repeat(line+"\n", 200)+"last\n"), 0644)
+	lines, err := tailFile(path, 150, Info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 150 || lines[0] != line || lines[149] != "last" {
+		t.Errorf("got %d lines, ending %q", len(lines), lines[len(lines)-1])
+	}
+}
+
+func TestDarkcalLog(t *testing.T) {
+	stdout := filepath.Join(t.TempDir(), "stdout")
+	os.WriteFile(stdout, []byte("DEBUG dark frame 1\nINFO dark frame 2\nWARN hot pixels\n"), 0644)
+	t.Setenv("FAKE_STDOUT", stdout)
+	router, st := newTestState(t, fakeConfig(t))
+	var storage StorageObject
+	decode(t, serve(router, "POST", "/storages", `{"mid": "m1"}`), &storage)
+
+	if w := serve(router, "GET", "/sockets/1/darkcal/log", ""); w.Code != http.StatusNotFound {
+		t.Errorf("before the darkcal, got %d", w.Code)
+	}
+	darkcal := `{"mid": "m1", "calibFileUrl": "http://localhost:23632/storages/m1/darkcal.h5",
+		"logUrl": "http://localhost:23632/storages/m1/darkcal.log", "logLevel": "INFO"}`
+	if w := serve(router, "POST", "/sockets/1/darkcal/start", darkcal); w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	waitApp(t, st, darkcalApp, "1")
+
+	b, err := os.ReadFile(filepath.Join(strings.TrimPrefix(storage.LinuxPath, "file:"), "darkcal.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "INFO dark frame 2\nWARN hot pixels\n"; string(b) != want {
+		t.Errorf("log is %q, want %q", b, want)
+	}
+	w := serve(router, "GET", "/sockets/1/darkcal/log?lines=1", "")
+	if w.Code != http.StatusOK || w.Body.String() != "WARN hot pixels\n" {
+		t.Errorf("got %d: %q", w.Code, w.Body.String())
+	}
+	if w := serve(router, "GET", "/sockets/1/darkcal/log?lines=x", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("lines=x got %d", w.Code)
+	}
+
+	bad := `{"mid": "m1", "logUrl": "http://elsewhere/darkcal.log"}`
+	if w := serve(router, "POST", "/sockets/2/darkcal/start", bad); w.Code != http.StatusBadRequest {
+		t.Errorf("unresolvable logUrl got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPostprimaryLogInLogDir(t *testing.T) {
+	stdout := filepath.Join(t.TempDir(), "stdout")
+	os.WriteFile(stdout, []byte("Processed 1 of 2 ZMWs\n"), 0644)
+	t.Setenv("FAKE_STDOUT", stdout)
+	config := fakeConfig(t)
+	router, st := newTestState(t, config)
+	serve(router, "POST", "/storages", `{"mid": "m1"}`)
+	if w := serve(router, "POST", "/postprimaries", postprimaryBody); w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	waitPostprimary(t, st, "m1")
+
+	// One line from baz2bam, one from ccs
+	w := serve(router, "GET", "/postprimaries/m1/log", "")
+	if want := "Processed 1 of 2 ZMWs\nProcessed 1 of 2 ZMWs\n"; w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("got %d: %q", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(config.LogDir, "postprimaries", "m1.log")); err != nil {
+		t.Error(err)
+	}
+	if w := serve(router, "GET", "/postprimaries/m9/log", ""); w.Code != http.StatusNotFound {
+		t.Errorf("m9 got %d", w.Code)
+	}
+}
+
+func TestLogUrlsStayInStorageOrLogDir(t *testing.T) {
+	router, st := newTestState(t, fakeConfig(t))
+	var storage StorageObject
+	decode(t, serve(router, "POST", "/storages", `{"mid": "m1"}`), &storage)
+	dir := strings.TrimPrefix(storage.LinuxPath, "file:")
+	os.Symlink("/etc", filepath.Join(dir, "etc"))
+	os.Symlink("/etc/hostname", filepath.Join(dir, "hostname.log"))
+	os.WriteFile(filepath.Join(dir, "m1.baz"), []byte("BAZ"), 0644)
+	os.WriteFile(filepath.Join(dir, "old.log.2"), nil, 0644)
+	for _, logUrl := range []string{
+		"file:" + st.Storages.indexPath(),
+		"file:" + st.Storages.auditPath(),
+		"http://localhost:23632/storages/m1/m1.baz",
+		"file:" + filepath.Join(dir, "old.log"),
+		"file:/etc/hostname",
+		"file:" + filepath.Join(dir, "../../../etc/hostname"),
+		"file:" + filepath.Join(dir, "etc", "hostname"),
+		"file:" + filepath.Join(dir, "hostname.log"),
+		"file:" + dir,
+	} {
+		body := `{"mid": "m1", "logUrl": "` + logUrl + `"}`
+		if w := serve(router, "POST", "/sockets/1/darkcal/start", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s got %d: %s", logUrl, w.Code, w.Body.String())
+		}
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "m1.baz")); string(b) != "BAZ" {
+		t.Errorf("m1.baz is now %q", b)
+	}
+
+	// discard: keeps no log, and leaves /dev/null alone.
+	body := `{"mid": "m1", "logUrl": "discard:"}`
+	if w := serve(router, "POST", "/sockets/1/darkcal/start", body); w.Code != http.StatusOK {
+		t.Fatalf("discard: got %d: %s", w.Code, w.Body.String())
+	}
+	waitApp(t, st, darkcalApp, "1")
+	if w := serve(router, "GET", "/sockets/1/darkcal/log", ""); w.Code != http.StatusNotFound {
+		t.Errorf("discard: log got %d", w.Code)
+	}
+	if info, err := os.Stat(os.DevNull); err != nil || info.Mode()&os.ModeDevice == 0 {
+		t.Errorf("%s is now %v, %v", os.DevNull, info, err)
+	}
+
+	// A log restored from an older pa-ws is not served from elsewhere.
+	st.Sockets.Update("2", func(s *SocketObject) error {
+		s.Darkcal.LogUrl = "file:/etc/hostname"
+		return nil
+	})
+	if w := serve(router, "GET", "/sockets/2/darkcal/log", ""); w.Code != http.StatusForbidden {
+		t.Errorf("/etc/hostname got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// A log that cannot be written does not stop the processes: they run
+// without one.
+func TestUnwritableLogDir(t *testing.T) {
+	stdout := filepath.Join(t.TempDir(), "stdout")
+	os.WriteFile(stdout, []byte("Processed 1 of 2 ZMWs\n"), 0644)
+	t.Setenv("FAKE_STDOUT", stdout)
+	config := fakeConfig(t)
+	config.LogDir = filepath.Join(stdout, "logs")
+	router, st := newTestState(t, config)
+	serve(router, "POST", "/storages", `{"mid": "m1"}`)
+
+	if w := serve(router, "POST", "/sockets/1/darkcal/start", `{"mid": "m1"}`); w.Code != http.StatusOK {
+		t.Fatalf("darkcal got %d: %s", w.Code, w.Body.String())
+	}
+	if ps := waitApp(t, st, darkcalApp, "1"); ps.CompletionStatus != CompletionSuccess {
+		t.Errorf("darkcal got %+v", ps)
+	}
+	if w := serve(router, "POST", "/postprimaries", postprimaryBody); w.Code != http.StatusOK {
+		t.Fatalf("postprimary got %d: %s", w.Code, w.Body.String())
+	}
+	// Progress still comes through, from a stand-in log
+	obj := waitPostprimary(t, st, "m1")
+	if obj.ProcessStatus.CompletionStatus != CompletionSuccess || obj.PostprimaryStatus.NumZmws == 0 {
+		t.Errorf("postprimary got %+v", obj)
+	}
+}
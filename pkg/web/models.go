@@ -17,6 +17,39 @@ type PawsStatusObject struct {
 
 	// Version of software, including git hash of last commit
 	Version string `json:"version"`
+
+	// Summary of each socket, in configuration order
+	Sockets []SocketSummaryObject `json:"sockets"`
+
+	// Counts of the child processes that are running or waiting to run
+	Processes ProcessCountsObject `json:"processes"`
+}
+
+// Summary of one socket for the top level status
+type SocketSummaryObject struct {
+
+	// The socket identifier, typically "1" thru "4".
+	SocketId string `json:"socketId"`
+
+	// Execution status of each app on the socket
+	Darkcal    ExecutionStatusEnum `json:"darkcal"`
+	Loadingcal ExecutionStatusEnum `json:"loadingcal"`
+	Basecaller ExecutionStatusEnum `json:"basecaller"`
+
+	// Movie context ID of the basecaller, if any
+	// Example: m123456_987654
+	Mid string `json:"mid"`
+}
+
+// Counts of child processes, by app
+type ProcessCountsObject struct {
+	Basecaller  int `json:"basecaller"`
+	Darkcal     int `json:"darkcal"`
+	Loadingcal  int `json:"loadingcal"`
+	Postprimary int `json:"postprimary"`
+
+	// Postprimaries waiting in the queue for a slot
+	QueuedPostprimaries int `json:"queuedPostprimaries"`
 }
 type LogLevelEnum string
 
@@ -82,7 +115,11 @@ type socketCommonObject struct {
 	LogUrl string `json:"logUrl"`
 
 	// Log severity threshold
-	LogLevel LogLevelEnum
+	LogLevel LogLevelEnum `json:"logLevel"`
+
+	// URL that the final object is POSTed to when the process completes (optional)
+	// Example: http://lims.example.com/paws/callback
+	CallbackUrl string `json:"callbackUrl"`
 
 	ProcessStatus ProcessStatusObject `json:"processStatus"`
 }
@@ -156,7 +193,7 @@ type SocketBasecallerObject struct {
 
 	// Reference SNR
 	// Example: 10
-	RefSnr int32 `json:"refSnr"1`
+	RefSnr int32 `json:"refSnr"`
 
 	// Source URL for the file to use for transmission of simulated data. Only local files are supported currently.
 	// Example: file://localhost/data/pa/sample_file.trc.h5
@@ -166,7 +203,7 @@ type SocketBasecallerObject struct {
 	// Example: null
 	SmrtBasecallerConfig string `json:"smrtBasecallerConfig"`
 
-	RtMetrics SocketBasecallerRTMetricsObject
+	RtMetrics SocketBasecallerRTMetricsObject `json:"rtMetrics"`
 
 	socketCommonObject
 }
@@ -176,6 +213,56 @@ type SocketBasecallerRTMetricsObject struct {
 	// Example: http://localhost:23632/storages/m123456_987654/rtmetrics_20210625_123456.xml
 	Url string `json:"url"`
 }
+
+// The metrics of one RT Metrics file, as parsed by pa-ws
+type RtMetricsObject struct {
+
+	// Source URL of the RT Metrics file
+	// Example: http://localhost:23632/storages/m123456_987654/rtmetrics_20210625_123456.xml
+	Url string `json:"url"`
+
+	// ISO8601 timestamp embedded in the file name
+	// Example: 2021-06-25T12:34:56.000Z
+	Timestamp string `json:"timestamp"`
+
+	// First frame of the block of frames the metrics cover
+	// Example: 1024000
+	StartFrame int64 `json:"startFrame"`
+
+	// Number of frames the metrics cover
+	// Example: 4096
+	NumFrames int64 `json:"numFrames"`
+
+	Analogs []RtMetricsAnalogObject `json:"analogs"`
+}
+
+// The RT metrics of one analog
+type RtMetricsAnalogObject struct {
+
+	// The nucleotide that the analog is attached to
+	// Example: C
+	BaseLabel BaseLabelEnum `json:"baseLabel"`
+
+	// Mean baseline level, in photoelectrons
+	// Example: 12.5
+	Baseline float64 `json:"baseline"`
+
+	// Standard deviation of the baseline, in photoelectrons
+	// Example: 4.2
+	BaselineSigma float64 `json:"baselineSigma"`
+
+	// Signal to noise ratio of the pulses
+	// Example: 11.3
+	Snr float64 `json:"snr"`
+
+	// Pulses per second per ZMW
+	// Example: 1.8
+	PulseRate float64 `json:"pulseRate"`
+
+	// Mean width of the pulses, in frames
+	// Example: 9.6
+	PulseWidth float64 `json:"pulseWidth"`
+}
 type AnalogObject struct {
 
 	// The nucleotide that the analog is attached to
@@ -233,7 +320,7 @@ type PostprimaryStatusObject struct {
 
 	// The total number of ZMWs processed so far
 	// Example: 25000000
-	numZmws int64 `json:"numZmws"`
+	NumZmws int64 `json:"numZmws"`
 
 	// The peak RSS memory usage in GiB used by baz2bam
 	// Example: 5.6
@@ -242,6 +329,10 @@ type PostprimaryStatusObject struct {
 	// The peak RSS memory usage in GiB used by ccs
 	// Example: 1.1
 	Ccs2bamPeakRssGb float64 `json:"ccs2bamPeakRssGb"`
+
+	// Place in line while the job waits to start, counting from 1. Zero once it has started.
+	// Example: 2
+	QueuePosition int32 `json:"queuePosition"`
 }
 type PostprimaryObject struct {
 
@@ -261,7 +352,7 @@ type PostprimaryObject struct {
 	LogUrl string `json:"logUrl"`
 
 	// Log severity threshold
-	LogLevel LogLevelEnum
+	LogLevel LogLevelEnum `json:"logLevel"`
 
 	// Destination URL for the prefix of all output files from baz2bam and/or ccs
 	// Example: http://localhost:23632/storages/0/m12346
@@ -295,6 +386,10 @@ type PostprimaryObject struct {
 	// Example: false
 	CcsOnInstrument bool `json:"ccsOnInstrument"`
 
+	// URL that the final object is POSTed to when the postprimary completes (optional)
+	// Example: http://lims.example.com/paws/callback
+	CallbackUrl string `json:"callbackUrl"`
+
 	PostprimaryStatus PostprimaryStatusObject `json:"status"`
 
 	ProcessStatus ProcessStatusObject `json:"processStatus"`
@@ -352,9 +447,81 @@ type StorageObject struct {
 
 	// Log severity threshold
 	// Example: "INFO"
-	LogLevel LogLevelEnum
+	LogLevel LogLevelEnum `json:"logLevel"`
 
 	Files         []StorageItemObject       `json:"files"`
 	Space         []StorageDiskReportObject `json:"space"`
 	ProcessStatus ProcessStatusObject       `json:"processStatus"`
 }
+
+// One change, as streamed by GET /events. Which of processStatus, postprimaryStatus and storage is set depends on the type.
+type EventObject struct {
+
+	// Increases by one with each event, from the start of pa-ws. It is also the id of the server-sent event.
+	// Example: 42
+	Id int64 `json:"id"`
+
+	// processStatus, postprimaryProgress, storageCreated, storageDeleted or storageFreed. It is also the type of the server-sent event.
+	// Example: processStatus
+	Type string `json:"type"`
+
+	// ISO8601 timestamp of the change
+	// Example: 2017-01-31T01:59:49.103Z
+	Timestamp string `json:"timestamp"`
+
+	// Socket of the app that changed, if any
+	// Example: 1
+	SocketId string `json:"socketId,omitempty"`
+
+	// Movie context ID of what changed
+	// Example: m123456_987654
+	Mid string `json:"mid,omitempty"`
+
+	// For processStatus, the process: basecaller, darkcal, loadingcal or postprimary
+	// Example: basecaller
+	App string `json:"app,omitempty"`
+
+	ProcessStatus     *ProcessStatusObject     `json:"processStatus,omitempty"`
+	PostprimaryStatus *PostprimaryStatusObject `json:"postprimaryStatus,omitempty"`
+	Storage           *StorageObject           `json:"storage,omitempty"`
+}
+
+// One POST of a final object to its callbackUrl, as GET /webhooks lists them.
+type WebhookDeliveryObject struct {
+
+	// Increases by one with each delivery, from the start of pa-ws. The POST carries it in the X-Paws-Delivery header.
+	// Example: 7
+	Id int64 `json:"id"`
+
+	// The callbackUrl
+	// Example: http://lims.example.com/paws/callback
+	Url string `json:"url"`
+
+	// Path of the resource that completed
+	// Example: /sockets/1/basecaller
+	Resource string `json:"resource"`
+
+	// Movie context ID of the resource
+	// Example: m123456_987654
+	Mid string `json:"mid"`
+
+	// PENDING until a POST succeeds, DELIVERED, or FAILED once the retries are used up
+	// Example: DELIVERED
+	Status string `json:"status"`
+
+	// How many times the POST was tried
+	// Example: 1
+	Attempts int32 `json:"attempts"`
+
+	// HTTP status of the last attempt, or 0 if it got no response
+	// Example: 200
+	ResponseCode int32 `json:"responseCode"`
+
+	// Why the last attempt failed, if it did
+	// Example: Post "http://lims.example.com/paws/callback": connection refused
+	Error string `json:"error,omitempty"`
+
+	// ISO8601 timestamp of the last attempt, or of the completion if there was none yet
+	// Example: 2017-01-31T01:59:49.103Z
+	Timestamp string `json:"timestamp"`
+}
@@ -0,0 +1,250 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unicode"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+var goldenStatus = ProcessStatusObject{
+	ExecutionStatus:  Complete,
+	CompletionStatus: CompletionSuccess,
+	Timestamp:        "2017-01-31T01:59:49.103Z",
+	ExitCode:         0,
+}
+
+var goldenCommon = socketCommonObject{
+	Mid:             "m123456_987654",
+	MaxMovieFrames:  100000,
+	MaxMovieSeconds: 1000,
+	MovieNumber:     7,
+	LogUrl:          "http://localhost:23632/storages/m123456_987654/basecaller.log",
+	LogLevel:        Info,
+	CallbackUrl:     "http://lims.example.com/paws/callback",
+	ProcessStatus:   goldenStatus,
+}
+
+var goldenBasecaller = SocketBasecallerObject{
+	Uuid:                     "123e4567-e89b-12d3-a456-426614174000",
+	BazUrl:                   "http://localhost:23632/storages/m123456_987654/thefile.baz",
+	TraceFileUrl:             "discard:",
+	Chiplayout:               "Minesweeper1.0",
+	DarkCalFileUrl:           "http://localhost:23632/storages/m123456_987654/darkcal.h5",
+	PixelSpreadFunction:      [][]float64{{0, 0.1, 0}, {0.1, 0.6, 0.1}, {0, 0.1, 0}},
+	CrosstalkFilter:          [][]float64{{0, 0.1, 0}, {0.1, 0.6, 0.1}, {0, 0.1, 0}},
+	Analogs:                  []AnalogObject{goldenAnalog},
+	SequencingRoi:            []int32{0, 0, 2048, 1980},
+	TraceFileRoi:             []int32{0, 0, 256, 32},
+	ExpectedFrameRate:        100,
+	PhotoelectronSensitivity: 1.4,
+	RefSnr:                   10,
+	SimulationFileUrl:        "file://localhost/data/pa/sample_file.trc.h5",
+	SmrtBasecallerConfig:     "{}",
+	RtMetrics:                SocketBasecallerRTMetricsObject{Url: "http://localhost:23632/storages/m123456_987654/rtmetrics_20210625_123456.xml"},
+	socketCommonObject:       goldenCommon,
+}
+
+var goldenAnalog = AnalogObject{
+	BaseLabel:             C,
+	RelativeAmp:           0.3,
+	InterPulseDistanceSec: 0.14,
+	ExcessNoiseCv:         3,
+	PulseWidthSec:         0.11,
+	Pw2SlowStepRatio:      0.19,
+	Ipd2SlowStepRatio:     0.14,
+}
+
+var goldenDarkcal = SocketDarkcalObject{
+	CalibFileUrl:       "http://localhost:23632/storages/m123456_987654/darkcal.h5",
+	socketCommonObject: goldenCommon,
+}
+
+var goldenLoadingcal = SocketLoadingcalObject{
+	DarkFrameFileUrl:   "http://localhost:23632/storages/m123456_987654/darkcal.h5",
+	CalibFileUrl:       "http://localhost:23632/storages/m123456_987654/loadingcal.h5",
+	socketCommonObject: goldenCommon,
+}
+
+var goldenStorageItem = StorageItemObject{
+	Url:        "http://localhost:23632/storages/m123456_987654/foobar1.bam",
+	Timestamp:  "2017-01-31T01:59:49.103998Z",
+	Size:       6593845929837,
+	Category:   "BAM",
+	SourceInfo: "baz2bam",
+}
+
+// One of each object in the REST schema, as the golden files have them.
+var goldens = map[string]interface{}{
+	"PawsStatusObject": &PawsStatusObject{
+		Uptime:        3723.5,
+		UptimeMessage: "1 hour, 2 minutes, 3 seconds",
+		Time:          1485827989.103,
+		Timestamp:     "2017-01-31T01:59:49.103Z",
+		Version:       "0.1.0+abc1234",
+		Sockets: []SocketSummaryObject{{
+			SocketId:   "1",
+			Darkcal:    Complete,
+			Loadingcal: Complete,
+			Basecaller: Running,
+			Mid:        "m123456_987654",
+		}},
+		Processes: ProcessCountsObject{Basecaller: 1, Postprimary: 2, QueuedPostprimaries: 1},
+	},
+	"ProcessStatusObject":    &goldenStatus,
+	"SocketObject":           &SocketObject{SocketId: "1", Darkcal: goldenDarkcal, Loadingcal: goldenLoadingcal, Basecaller: goldenBasecaller},
+	"SocketBasecallerObject": &goldenBasecaller,
+	"SocketDarkcalObject":    &goldenDarkcal,
+	"SocketLoadingcalObject": &goldenLoadingcal,
+	"AnalogObject":           &goldenAnalog,
+	"RtMetricsObject": &RtMetricsObject{
+		Url:        "http://localhost:23632/storages/m123456_987654/rtmetrics_20210625_123456.xml",
+		Timestamp:  "2021-06-25T12:34:56.000Z",
+		StartFrame: 1024000,
+		NumFrames:  4096,
+		Analogs: []RtMetricsAnalogObject{
+			{BaseLabel: A, Baseline: 12.5, BaselineSigma: 4.2, Snr: 11.3, PulseRate: 1.8, PulseWidth: 9.6},
+		},
+	},
+	"PostprimaryObject": &PostprimaryObject{
+		Mid:                    "m123456_987654",
+		BazFileUrl:             "http://localhost:23632/storages/m123456_987654/m123456_987654.baz",
+		Uuid:                   "123e4567-e89b-12d3-a456-426614174000",
+		LogUrl:                 "http://localhost:23632/storages/m123456_987654/postprimary.log",
+		LogLevel:               Warn,
+		OutputPrefixUrl:        "http://localhost:23632/storages/m123456_987654/m123456_987654",
+		OutputStatsXmlUrl:      "http://localhost:23632/storages/m123456_987654/m123456_987654.stats.xml",
+		OutputStatsH5Url:       "http://localhost:23632/storages/m123456_987654/m123456_987654.sts.h5",
+		OutputReduceStatsH5Url: "http://localhost:23632/storages/m123456_987654/m123456_987654.rsts.h5",
+		Chiplayout:             "Minesweeper1.0",
+		SubreadsetMetadataXml:  "<SubreadSets/>",
+		IncludeKinetics:        true,
+		CcsOnInstrument:        true,
+		CallbackUrl:            "http://lims.example.com/paws/callback",
+		PostprimaryStatus: PostprimaryStatusObject{
+			OutputUrls:        []string{"http://localhost:23632/storages/m123456_987654/m123456_987654.subreads.bam"},
+			Progress:          0.74,
+			Baz2bamZmwsPerMin: 3.6e6,
+			Ccs2bamZmwsPerMin: 0.4e6,
+			NumZmws:           25000000,
+			Baz2bamPeakRssGb:  5.6,
+			Ccs2bamPeakRssGb:  1.2,
+			QueuePosition:     0,
+		},
+		ProcessStatus: goldenStatus,
+	},
+	"StorageItemObject":       &goldenStorageItem,
+	"StorageDiskReportObject": &StorageDiskReportObject{TotalSpace: 6593845929837, FreeSpace: 6134262344238},
+	// Every field is set, though an event only ever has one of
+	// processStatus, postprimaryStatus and storage.
+	"EventObject": &EventObject{
+		Id:                42,
+		Type:              EventProcessStatus,
+		Timestamp:         "2017-01-31T01:59:49.103Z",
+		SocketId:          "1",
+		Mid:               "m123456_987654",
+		App:               "basecaller",
+		ProcessStatus:     &goldenStatus,
+		PostprimaryStatus: &PostprimaryStatusObject{Progress: 0.74, NumZmws: 25000000},
+		Storage:           &StorageObject{Mid: "m123456_987654", RootUrl: "http://localhost:23632/storages/m123456_987654"},
+	},
+	"WebhookDeliveryObject": &WebhookDeliveryObject{
+		Id:           7,
+		Url:          "http://lims.example.com/paws/callback",
+		Resource:     "/sockets/1/basecaller",
+		Mid:          "m123456_987654",
+		Status:       DeliveryFailed,
+		Attempts:     6,
+		ResponseCode: 503,
+		Error:        "503 Service Unavailable",
+		Timestamp:    "2017-01-31T01:59:49.103Z",
+	},
+	"StorageObject": &StorageObject{
+		Mid:           "m123456_987654",
+		RootUrl:       "http://localhost:23632/storages/m123456_987654",
+		LinuxPath:     "file:/data/pa/m123456_987654",
+		LogUrl:        "http://localhost:23632/storages/m123456_987654/storage.log",
+		LogLevel:      Debug,
+		Files:         []StorageItemObject{goldenStorageItem},
+		Space:         []StorageDiskReportObject{{TotalSpace: 6593845929837, FreeSpace: 6134262344238}},
+		ProcessStatus: goldenStatus,
+	},
+}
+
+// Every object encodes as its golden file, and decodes from it,
+// strictly, back to the same object.
+func TestModelsGolden(t *testing.T) {
+	for name, obj := range goldens {
+		path := filepath.Join("testdata", "golden", name+".json")
+		got, err := json.MarshalIndent(obj, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, '\n')
+		if *update {
+			if err := os.WriteFile(path, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s encodes as\n%s", name, got)
+		}
+
+		decoded := reflect.New(reflect.TypeOf(obj).Elem()).Interface()
+		if err := decodeJSON(bytes.NewReader(want), decoded, true); err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if !reflect.DeepEqual(decoded, obj) {
+			t.Errorf("%s decodes as %+v", name, decoded)
+		}
+
+		var generic interface{}
+		json.Unmarshal(want, &generic)
+		checkCamelCase(t, name, generic)
+	}
+}
+
+// Every key in the wire format is camelCase.
+func checkCamelCase(t *testing.T, path string, v interface{}) {
+	t.Helper()
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for key, value := range x {
+			if !unicode.IsLower(rune(key[0])) {
+				t.Errorf("%s.%s is not camelCase", path, key)
+			}
+			checkCamelCase(t, path+"."+key, value)
+		}
+	case []interface{}:
+		for _, value := range x {
+			checkCamelCase(t, path+"[]", value)
+		}
+	}
+}
+
+func TestStrictRequests(t *testing.T) {
+	config := fakeConfig(t)
+	config.StrictRequests = true
+	router, st := newTestState(t, config)
+	body := `{"calibFileUrl": "discard:", "calibFileUlr": "discard:"}`
+	if w := serve(router, "POST", "/sockets/1/darkcal/start", body); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown field: got %d", w.Code)
+	}
+	if w := serve(router, "POST", "/sockets/1/darkcal/start", `{"calibFileUrl": "discard:"} {}`); w.Code != http.StatusBadRequest {
+		t.Errorf("trailing value: got %d", w.Code)
+	}
+	if w := serve(router, "POST", "/sockets/1/darkcal/start", `{"calibFileUrl": "discard:"}`); w.Code != http.StatusOK {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+	waitApp(t, st, darkcalApp, "1")
+}
@@ -0,0 +1,38 @@
+package web
+
+import (
+	"bytes"
+	_ "embed"
+	"github.com/gin-gonic/gin"
+	"html/template"
+	"net/http"
+	"pacb.com/seq/paws/web/views"
+)
+
+// The OpenAPI 3 description of the routes and objects of pa-ws. It is
+// generated from this package, and checked against it, by
+// openapi_test.go; after changing a route or an object, run
+//
+//	go test ./pkg/web -run Openapi -update
+//
+//go:embed openapi.json
+var openapiSpec []byte
+
+// Swagger UI for the spec. It loads the UI itself from
+// Config.SwaggerUiUrl.
+var docsTemplate = template.Must(template.New("docs").Parse(views.Swagger))
+
+// Returns the OpenAPI specification of pa-ws.
+func (st *State) getOpenapi(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", openapiSpec)
+}
+
+// Returns Swagger UI, to browse the OpenAPI specification.
+func (st *State) getDocs(c *gin.Context) {
+	var b bytes.Buffer
+	if err := docsTemplate.Execute(&b, st.Config.SwaggerUiUrl); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", b.Bytes())
+}
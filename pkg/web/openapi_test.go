@@ -0,0 +1,416 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-contrib/sse"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// What the spec says about the handler of a route, beyond its path
+// and doc comment.
+type operation struct {
+	request  interface{} // body, if any
+	response interface{} // body, if any
+	status   int         // of success; 200 if zero
+	media    []string    // other types of content it may respond with
+	query    []string    // optional query parameters, all strings
+	events   interface{} // data of each server-sent event, if it streams
+	conflict interface{} // body of a 409, if not an ErrorObject
+}
+
+// Every handler in AddRoutes, by name.
+var operations = map[string]operation{
+	"getStatus":                  {response: PawsStatusObject{}},
+	"getConfig":                  {response: map[string]interface{}{}},
+	"getOpenapi":                 {response: map[string]interface{}{}},
+	"getDocs":                    {media: []string{"text/html"}},
+	"getEvents":                  {events: EventObject{}, query: []string{"socketId", "mid"}},
+	"listWebhookDeliveries":      {response: []WebhookDeliveryObject{}, query: []string{"mid"}},
+	"getSockets":                 {response: []string{}},
+	"getSocketById":              {response: SocketObject{}},
+	"resetSockets":               {response: []string{}, conflict: ResetConflictObject{}},
+	"resetSocketById":            {response: SocketObject{}, conflict: ResetConflictObject{}},
+	"getImageBySocketId":         {media: []string{"image/png", "application/octet-stream"}, query: []string{"format", "roi", "downsample"}},
+	"getBasecallerBySocketId":    {response: SocketBasecallerObject{}},
+	"startBasecallerBySocketId":  {request: SocketBasecallerObject{}, response: SocketBasecallerObject{}, conflict: TransitionError{}},
+	"stopBasecallerBySocketId":   {response: SocketBasecallerObject{}},
+	"resetBasecallerBySocketId":  {response: SocketBasecallerObject{}, conflict: TransitionError{}},
+	"getRtMetricsBySocketId":     {response: []RtMetricsObject{}},
+	"getBasecallerLogBySocketId": {media: []string{"text/plain"}, query: []string{"lines"}},
+	"getDarkcalLogBySocketId":    {media: []string{"text/plain"}, query: []string{"lines"}},
+	"getLoadingcalLogBySocketId": {media: []string{"text/plain"}, query: []string{"lines"}},
+	"getPostprimaryLogByMid":     {media: []string{"text/plain"}, query: []string{"lines"}},
+	"getDarkcalBySocketId":       {response: SocketDarkcalObject{}},
+	"startDarkcalBySocketId":     {request: SocketDarkcalObject{}, response: SocketDarkcalObject{}, conflict: TransitionError{}},
+	"stopDarkcalBySocketId":      {response: SocketDarkcalObject{}},
+	"resetDarkcalBySocketId":     {response: SocketDarkcalObject{}, conflict: TransitionError{}},
+	"getLoadingcalBySocketId":    {response: SocketLoadingcalObject{}},
+	"startLoadingcalBySocketId":  {request: SocketLoadingcalObject{}, response: SocketLoadingcalObject{}, conflict: TransitionError{}},
+	"stopLoadingcalBySocketId":   {response: SocketLoadingcalObject{}},
+	"resetLoadingcalBySocketId":  {response: SocketLoadingcalObject{}, conflict: TransitionError{}},
+	"listStorageMids":            {response: []string{}},
+	"createStorage":              {request: StorageObject{}, response: StorageObject{}, status: http.StatusCreated},
+	"getStorageByMid":            {response: StorageObject{}},
+	"deleteStorageByMid":         {response: StorageObject{}},
+	"freeStorageByMid":           {response: FreeReportObject{}},
+	"getStorageFile":             {response: []StorageItemObject{}, media: []string{"application/octet-stream"}},
+	"listPostprimaryMids":        {response: []string{}},
+	"startPostprimary":           {request: PostprimaryObject{}, response: PostprimaryObject{}},
+	"deletePostprimaries":        {response: []DeleteResultObject{}, query: []string{"status", "olderThan", "midPrefix", "force"}},
+	"getPostprimaryByMid":        {response: PostprimaryObject{}},
+	"deletePostprimaryByMid":     {response: PostprimaryObject{}},
+	"stopPostprimaryByMid":       {response: PostprimaryObject{}},
+}
+
+// Values of the enum types, as the spec lists them.
+var enums = map[reflect.Type][]string{
+	reflect.TypeOf(LogLevelEnum("")):        {string(Debug), Info, Warn, Error},
+	reflect.TypeOf(BaseLabelEnum("")):       {string(N), A, C, G, T},
+	reflect.TypeOf(ExecutionStatusEnum("")): {string(Unknown), Ready, Running, Complete},
+}
+
+// Doc comments of the funcs, types and fields ("Type.Field") of the package.
+func docComments(t *testing.T) map[string]string {
+	t.Helper()
+	names, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := make(map[string]string)
+	fset := token.NewFileSet()
+	for _, name := range names {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, nil, parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				// The handlers are methods of State.
+				if d.Recv == nil || types.ExprString(d.Recv.List[0].Type) == "*State" {
+					docs[d.Name.Name] = d.Doc.Text()
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					ts, ok := spec.(*ast.TypeSpec)
+					if !ok {
+						continue
+					}
+					docs[ts.Name.Name] = d.Doc.Text() + ts.Doc.Text()
+					st, ok := ts.Type.(*ast.StructType)
+					if !ok {
+						continue
+					}
+					for _, field := range st.Fields.List {
+						for _, n := range field.Names {
+							docs[ts.Name.Name+"."+n.Name] = field.Doc.Text() + field.Comment.Text()
+						}
+					}
+				}
+			}
+		}
+	}
+	return docs
+}
+
+// Builds the spec from the routes of router and the handlers and
+// objects of this package.
+type specBuilder struct {
+	docs    map[string]string
+	schemas map[string]interface{}
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// Schema of values of type t, adding named structs and enums to the
+// components as they come up.
+func (b *specBuilder) schema(t reflect.Type) map[string]interface{} {
+	if values, ok := enums[t]; ok {
+		if _, done := b.schemas[t.Name()]; !done {
+			b.schemas[t.Name()] = map[string]interface{}{"type": "string", "enum": values}
+		}
+		return ref(t.Name())
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Ptr:
+		return b.schema(t.Elem())
+	case reflect.Map, reflect.Interface:
+		return map[string]interface{}{"type": "object"}
+	case reflect.Struct:
+		if _, done := b.schemas[t.Name()]; !done {
+			b.schemas[t.Name()] = nil // for recursion
+			props := make(map[string]interface{})
+			b.properties(t, props)
+			s := map[string]interface{}{"type": "object", "properties": props}
+			if doc := strings.TrimSpace(b.docs[t.Name()]); doc != "" {
+				s["description"] = doc
+			}
+			b.schemas[t.Name()] = s
+		}
+		return ref(t.Name())
+	}
+	panic("no schema for " + t.String())
+}
+
+// Adds the properties of struct t, including those of embedded structs.
+func (b *specBuilder) properties(t reflect.Type, props map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			b.properties(f.Type, props)
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+		s := b.schema(f.Type)
+		if doc := strings.TrimSpace(b.docs[t.Name()+"."+f.Name]); doc != "" {
+			if _, isRef := s["$ref"]; isRef {
+				// Siblings of $ref are ignored in OpenAPI 3.0.
+				s = map[string]interface{}{"allOf": []interface{}{s}}
+			}
+			s["description"] = doc
+		}
+		props[name] = s
+	}
+}
+
+func (b *specBuilder) content(v interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": b.schema(reflect.TypeOf(v))},
+	}
+}
+
+func (b *specBuilder) operation(t *testing.T, route string, handler string) map[string]interface{} {
+	op, ok := operations[handler]
+	if !ok {
+		t.Errorf("%s: no operation for handler %s", route, handler)
+	}
+	doc := strings.TrimSpace(b.docs[handler])
+	summary := strings.SplitN(doc, "\n", 2)[0]
+	o := map[string]interface{}{
+		"operationId": handler,
+		"summary":     summary,
+		"tags":        []string{strings.Split(strings.TrimPrefix(route, "/"), "/")[0]},
+	}
+	if doc != summary {
+		o["description"] = doc
+	}
+	var params []interface{}
+	for _, part := range strings.Split(route, "/") {
+		if strings.HasPrefix(part, "{") {
+			params = append(params, map[string]interface{}{
+				"name": strings.Trim(part, "{}"), "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	for _, q := range op.query {
+		params = append(params, map[string]interface{}{
+			"name": q, "in": "query",
+			"schema": map[string]interface{}{"type": "string"},
+		})
+	}
+	if params != nil {
+		o["parameters"] = params
+	}
+	if op.request != nil {
+		o["requestBody"] = map[string]interface{}{"required": true, "content": b.content(op.request)}
+	}
+	status := op.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]interface{}{"description": http.StatusText(status)}
+	content := make(map[string]interface{})
+	if op.response != nil {
+		content = b.content(op.response)
+	}
+	if op.events != nil {
+		content[sse.ContentType] = map[string]interface{}{"schema": b.schema(reflect.TypeOf(op.events))}
+	}
+	for _, media := range op.media {
+		content[media] = map[string]interface{}{
+			"schema": map[string]interface{}{"type": "string", "format": "binary"},
+		}
+	}
+	if len(content) > 0 {
+		success["content"] = content
+	}
+	responses := map[string]interface{}{
+		strconv.Itoa(status): success,
+		"default": map[string]interface{}{
+			"description": "An error, e.g. 400, 404 or 409",
+			"content":     b.content(ErrorObject{}),
+		},
+	}
+	if op.conflict != nil {
+		responses[strconv.Itoa(http.StatusConflict)] = map[string]interface{}{
+			"description": http.StatusText(http.StatusConflict),
+			"content":     b.content(op.conflict),
+		}
+	}
+	o["responses"] = responses
+	return o
+}
+
+// Gin path to OpenAPI path: /storages/:mid/*path is /storages/{mid}/{path}.
+func openapiPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*") {
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func buildSpec(t *testing.T) []byte {
+	t.Helper()
+	router := newTestRouter(t, testConfig(t))
+	b := &specBuilder{docs: docComments(t), schemas: make(map[string]interface{})}
+	paths := make(map[string]map[string]interface{})
+	for _, r := range router.Routes() {
+		path := openapiPath(r.Path)
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+		// e.g. pacb.com/seq/paws/pkg/web.(*State).getStatus-fm
+		handler := strings.TrimSuffix(r.Handler[strings.LastIndex(r.Handler, ".")+1:], "-fm")
+		paths[path][strings.ToLower(r.Method)] = b.operation(t, path, handler)
+	}
+	spec := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "pa-ws",
+			"description": "Primary analysis web services: sockets, storages and postprimaries.",
+			"version":     release,
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": b.schemas},
+	}
+	out, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(out, '\n')
+}
+
+// The embedded spec is what this package generates.
+func TestOpenapiUpToDate(t *testing.T) {
+	spec := buildSpec(t)
+	if *update {
+		if err := os.WriteFile("openapi.json", spec, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if !bytes.Equal(spec, openapiSpec) {
+		t.Error("openapi.json is out of date; run go test ./pkg/web -run Openapi -update")
+	}
+}
+
+type servedSpec struct {
+	Paths      map[string]map[string]interface{} `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func getSpec(t *testing.T) servedSpec {
+	t.Helper()
+	var spec servedSpec
+	decode(t, serve(newTestRouter(t, testConfig(t)), "GET", "/openapi.json", ""), &spec)
+	return spec
+}
+
+// Every route has an operation in the spec, and every operation a route.
+func TestOpenapiRoutes(t *testing.T) {
+	spec := getSpec(t)
+	router := newTestRouter(t, testConfig(t))
+	routes := make(map[string]bool)
+	for _, r := range router.Routes() {
+		path, method := openapiPath(r.Path), strings.ToLower(r.Method)
+		routes[method+" "+path] = true
+		if _, ok := spec.Paths[path][method]; !ok {
+			t.Errorf("%s %s is not in the spec", r.Method, r.Path)
+		}
+	}
+	for path, ops := range spec.Paths {
+		for method := range ops {
+			if !routes[method+" "+path] {
+				t.Errorf("%s %s is in the spec, but not routed", method, path)
+			}
+		}
+	}
+}
+
+// Every object has the properties the spec says, no more and no less.
+func TestOpenapiModels(t *testing.T) {
+	spec := getSpec(t)
+	for name, obj := range goldens {
+		b, _ := json.Marshal(obj)
+		var fields map[string]interface{}
+		json.Unmarshal(b, &fields)
+		var got, want []string
+		for field := range fields {
+			got = append(got, field)
+		}
+		for prop := range spec.Components.Schemas[name].Properties {
+			want = append(want, prop)
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s has %v, the spec %v", name, got, want)
+		}
+	}
+}
+
+// The docs page is served from the binary, loading Swagger UI from
+// where it is configured to.
+func TestDocs(t *testing.T) {
+	config := testConfig(t)
+	config.SwaggerUiUrl = "/static/swagger-ui"
+	w := serve(newTestRouter(t, config), "GET", "/docs", "")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{`href="/static/swagger-ui/swagger-ui.css"`, `src="/static/swagger-ui/swagger-ui-bundle.js"`, `url: "/openapi.json"`} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("no %s in %s", want, w.Body)
+		}
+	}
+}
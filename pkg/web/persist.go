@@ -0,0 +1,283 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Everything needed to pick up where an earlier pa-ws left off.
+// Storages are not in here; the StorageManager keeps its own index.
+type snapshot struct {
+	Sockets       []SocketObject      `json:"sockets"`
+	Postprimaries []PostprimaryObject `json:"postprimaries"`
+
+	// Pid of each running child, by Supervisor key
+	Pids map[string]int `json:"pids"`
+
+	// Start time of each of those children, and the boot they ran in,
+	// so that a process that got one of their pids since is not taken
+	// for the child
+	Starts map[string]uint64 `json:"starts"`
+	BootId string            `json:"bootId"`
+}
+
+// StateStore keeps the latest snapshot of the state in a file, so that
+// it survives a restart. Writes are atomic, so the file is always
+// either the previous snapshot or the next.
+type StateStore struct {
+	path  string
+	dirty chan struct{}
+	mu    sync.Mutex
+
+	// Requests to write what is still dirty, each closed once it is
+	flushes chan chan struct{}
+
+	// Whether the persist goroutine is there to take flushes; under mu
+	persisting bool
+}
+
+// NewStateStore returns a store that keeps its file in dir. With no
+// dir, it returns nil, which stores nothing.
+func NewStateStore(dir string) *StateStore {
+	if dir == "" {
+		return nil
+	}
+	return &StateStore{
+		path:    filepath.Join(dir, "state.json"),
+		dirty:   make(chan struct{}, 1),
+		flushes: make(chan chan struct{}),
+	}
+}
+
+// Reads the last snapshot, or nil if there is none.
+func (s *StateStore) load() (*snapshot, error) {
+	if s == nil {
+		return nil, nil
+	}
+	b, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return nil, fmt.Errorf("%s: %v", s.path, err)
+	}
+	return &snap, nil
+}
+
+func (s *StateStore) save(snap snapshot) error {
+	b, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Marks the state as changed. It returns at once; changes that come
+// in while a snapshot is being written are coalesced into the next.
+func (s *StateStore) changed() {
+	if s == nil {
+		return
+	}
+	select {
+	case s.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// Returns once every change marked so far is written. Until the state
+// is persisting, i.e. restored, there is nothing to write.
+func (s *StateStore) flush() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	persisting := s.persisting
+	s.mu.Unlock()
+	if !persisting {
+		return
+	}
+	done := make(chan struct{})
+	s.flushes <- done
+	<-done
+}
+
+// Save writes a snapshot of the state now.
+func (st *State) Save() error {
+	if st.Store == nil {
+		return nil
+	}
+	snap := snapshot{
+		Sockets:       []SocketObject{},
+		Postprimaries: []PostprimaryObject{},
+		Pids:          st.Processes.Pids(),
+		Starts:        st.Processes.Starts(),
+		BootId:        bootId(),
+	}
+	for _, id := range st.Sockets.Ids() {
+		if obj, ok := st.Sockets.Get(id); ok {
+			snap.Sockets = append(snap.Sockets, obj)
+		}
+	}
+	for _, mid := range st.Postprimaries.Mids() {
+		if obj, ok := st.Postprimaries.Get(mid); ok {
+			snap.Postprimaries = append(snap.Postprimaries, obj)
+		}
+	}
+	return st.Store.save(snap)
+}
+
+// Writes a snapshot after every change, until the state is closed.
+// Snapshots are written from here, not by whoever made the change,
+// because changes are made while holding locks that Save needs.
+func (st *State) persist() {
+	for {
+		select {
+		case <-st.Store.dirty:
+			st.persistNow()
+		case done := <-st.Store.flushes:
+			select {
+			case <-st.Store.dirty:
+				st.persistNow()
+			default:
+			}
+			close(done)
+		case <-st.done:
+			return
+		}
+	}
+}
+
+func (st *State) persistNow() {
+	if err := st.Save(); err != nil {
+		log.Printf("state: %v", err)
+	}
+}
+
+// Restores the last snapshot, then keeps the store up to date. Apps
+// and postprimaries that were RUNNING are adopted if their process is
+// still around, and ORPHANED if not. Postprimaries that were waiting
+// in the queue are queued again. Sockets that are no longer configured
+// are dropped.
+func (st *State) restore() error {
+	snap, err := st.Store.load()
+	if err != nil {
+		return err
+	}
+	if snap != nil {
+		st.restoreSockets(snap)
+		st.restorePostprimaries(snap)
+	}
+	if st.Store != nil {
+		st.Sockets.onChange = st.Store.changed
+		st.Postprimaries.onChange = st.Store.changed
+		st.Store.mu.Lock()
+		st.Store.persisting = true
+		st.Store.mu.Unlock()
+		go st.persist()
+		st.Store.changed()
+	}
+	return nil
+}
+
+func (st *State) restoreSockets(snap *snapshot) {
+	for _, obj := range snap.Sockets {
+		if !st.Sockets.restore(obj) {
+			continue
+		}
+		for _, app := range socketApps {
+			if app.status(&obj).ExecutionStatus != Running {
+				continue
+			}
+			key := app.key(obj.SocketId)
+			appLog := st.socketLog(app, &obj, true)
+			p := st.adopt(key, snap, appLog.closing(app.follow(st.Sockets, obj.SocketId)))
+			st.pollLog(appLog, p)
+			if p != nil && app.started != nil {
+				app.started(st, obj.SocketId, p)
+			}
+		}
+	}
+}
+
+func (st *State) restorePostprimaries(snap *snapshot) {
+	var queued []*postprimaryJob
+	for _, obj := range snap.Postprimaries {
+		job := &postprimaryJob{mid: obj.Mid, state: st, done: make(chan struct{})}
+		ready := false
+		if obj.ProcessStatus.ExecutionStatus == Ready {
+			if j, err := st.newPostprimaryJob(obj); err != nil {
+				obj.ProcessStatus = failedStatus()
+			} else {
+				job, ready = j, true
+			}
+		}
+		if err := st.Postprimaries.add(obj, job); err != nil {
+			continue
+		}
+		if ready {
+			queued = append(queued, job)
+		} else if obj.ProcessStatus.ExecutionStatus == Running {
+			// Only the step that was running is adopted; later
+			// steps never start. It holds its place in the queue
+			// until it exits.
+			registry, queue := st.Postprimaries, st.Queue
+			peakRssGb := math.Max(obj.PostprimaryStatus.Baz2bamPeakRssGb, obj.PostprimaryStatus.Ccs2bamPeakRssGb)
+			jobLog := st.postprimaryLog(obj)
+			queue.adopt(job)
+			p := st.adopt(job.key(), snap, jobLog.closing(func(status ProcessStatusObject) {
+				registry.updateJob(job, func(obj *PostprimaryObject) {
+					obj.ProcessStatus = status
+				})
+				if status.ExecutionStatus == Complete {
+					queue.done(job, peakRssGb)
+					close(job.done)
+				}
+			}))
+			st.pollLog(jobLog, p)
+		}
+	}
+	// Only once the adopted jobs are counted as running
+	for _, job := range queued {
+		st.Queue.enqueue(job)
+	}
+}
+
+// Adopts the process that snap has under key, if it is still running,
+// or else reports it ORPHANED through onChange and returns nil. It is
+// only still running if it is the same boot, and the pid's start time
+// is unchanged; otherwise the pid may well be some other process now.
+func (st *State) adopt(key string, snap *snapshot, onChange func(ProcessStatusObject)) *Process {
+	pid, start := snap.Pids[key], snap.Starts[key]
+	if snap.BootId == bootId() && sameProcess(pid, start) {
+		interval := st.Config.OutputPollInterval
+		if interval <= 0 {
+			interval = time.Second
+		}
+		return st.Processes.Adopt(key, pid, start, interval, onChange)
+	}
+	onChange(ProcessStatusObject{
+		ExecutionStatus:  Complete,
+		CompletionStatus: CompletionOrphaned,
+		Timestamp:        timestamp(time.Now()),
+		ExitCode:         -1,
+	})
+	return nil
+}
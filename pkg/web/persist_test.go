@@ -0,0 +1,221 @@
+package web
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Polls the snapshot until cond holds.
+func waitSnapshot(t *testing.T, store *StateStore, cond func(*snapshot) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if snap, _ := store.load(); snap != nil && cond(snap) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("snapshot never caught up")
+}
+
+// A snapshot that cannot be read fails the start, rather than hanging it.
+func TestRestartFromCorruptSnapshot(t *testing.T) {
+	config := testConfig(t)
+	config.StateDir = t.TempDir()
+	os.WriteFile(filepath.Join(config.StateDir, "state.json"), []byte("{garbage"), 0644)
+	errs := make(chan error, 1)
+	go func() {
+		_, err := AddRoutes(gin.New(), config)
+		errs <- err
+	}()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("started from a corrupt snapshot")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("AddRoutes hung")
+	}
+}
+
+func TestRestartAdoptsRunningProcess(t *testing.T) {
+	t.Setenv("FAKE_SLEEP", "10")
+	config := fakeConfig(t)
+	config.StateDir = t.TempDir()
+	router, st := newTestState(t, config)
+	serve(router, "POST", "/sockets/1/basecaller/start", basecallerBody)
+	waitSnapshot(t, st.Store, func(snap *snapshot) bool {
+		return snap.Pids["sockets/1/basecaller"] > 0
+	})
+
+	// pa-ws restarts, while the basecaller keeps going.
+	st.Close()
+	router, st = newTestState(t, config)
+	var obj SocketBasecallerObject
+	decode(t, serve(router, "GET", "/sockets/1/basecaller", ""), &obj)
+	if obj.ProcessStatus.ExecutionStatus != Running || obj.Mid != "m123456_987654" {
+		t.Fatalf("got %+v", obj)
+	}
+	if w := serve(router, "POST", "/sockets/1/basecaller/stop", ""); w.Code != http.StatusOK {
+		t.Errorf("got %d", w.Code)
+	}
+	if ps := waitBasecaller(t, st, "1").ProcessStatus; ps.CompletionStatus != CompletionAborted {
+		t.Errorf("got %+v", ps)
+	}
+}
+
+// The child writes to its log itself, so it carries on writing when
+// pa-ws restarts, rather than dying of SIGPIPE.
+func TestRestartKeepsAdoptedProcessLogging(t *testing.T) {
+	t.Setenv("FAKE_LINES", "30")
+	config := fakeConfig(t)
+	config.StateDir = t.TempDir()
+	router, st := newTestState(t, config)
+	serve(router, "POST", "/sockets/1/basecaller/start", basecallerBody)
+	var pid int
+	waitSnapshot(t, st.Store, func(snap *snapshot) bool {
+		pid = snap.Pids["sockets/1/basecaller"]
+		return pid > 0
+	})
+	path, _ := filepath.EvalSymlinks(filepath.Join(config.LogDir, "sockets", "1", "basecaller.log"))
+	if stdout, err := os.Readlink(fmt.Sprintf("/proc/%d/fd/1", pid)); err != nil || stdout != path {
+		t.Errorf("stdout of the child is %q (%v), not %s", stdout, err, path)
+	}
+
+	st.Close()
+	router, st = newTestState(t, config)
+	waitBasecaller(t, st, "1")
+	w := serve(router, "GET", "/sockets/1/basecaller/log?lines=1", "")
+	if w.Code != http.StatusOK || w.Body.String() != "line 30\n" {
+		t.Errorf("got %d: %q", w.Code, w.Body.String())
+	}
+}
+
+func TestRestartMarksOrphans(t *testing.T) {
+	config := fakeConfig(t)
+	config.StateDir = t.TempDir()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	dark := newSocketObject("2")
+	dark.Darkcal.ProcessStatus = runningStatus()
+	prefix := "file:" + filepath.Join(t.TempDir(), "m2")
+	snap := snapshot{
+		Sockets: []SocketObject{*dark, *newSocketObject("9")},
+		Postprimaries: []PostprimaryObject{
+			{Mid: "m1", ProcessStatus: runningStatus()},
+			{Mid: "m2", BazFileUrl: "file:/data/m2.baz", OutputPrefixUrl: prefix, ProcessStatus: readyStatus()},
+		},
+		Pids: map[string]int{"sockets/2/darkcal": cmd.Process.Pid},
+	}
+	if err := NewStateStore(config.StateDir).save(snap); err != nil {
+		t.Fatal(err)
+	}
+
+	router, st := newTestState(t, config)
+	if ps := waitApp(t, st, darkcalApp, "2"); ps.CompletionStatus != CompletionOrphaned || ps.ExitCode != -1 {
+		t.Errorf("darkcal got %+v", ps)
+	}
+	if w := serve(router, "GET", "/sockets/9", ""); w.Code != http.StatusNotFound {
+		t.Errorf("unconfigured socket: got %d", w.Code)
+	}
+	if ps := waitPostprimary(t, st, "m1").ProcessStatus; ps.CompletionStatus != CompletionOrphaned {
+		t.Errorf("m1 got %+v", ps)
+	}
+	if ps := waitPostprimary(t, st, "m2").ProcessStatus; ps.CompletionStatus != CompletionSuccess {
+		t.Errorf("queued m2 got %+v", ps)
+	}
+	waitSnapshot(t, st.Store, func(snap *snapshot) bool {
+		return len(snap.Postprimaries) == 2 &&
+			snap.Postprimaries[1].ProcessStatus.CompletionStatus == CompletionSuccess &&
+			snap.Sockets[1].Darkcal.ProcessStatus.CompletionStatus == CompletionOrphaned
+	})
+}
+
+func TestRestartOrphansPidOfAnotherProcess(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	pid, start := cmd.Process.Pid, processStart(cmd.Process.Pid)
+	if start == 0 {
+		t.Fatal("no start time")
+	}
+	for name, snap := range map[string]snapshot{
+		"other start": {Starts: map[string]uint64{"sockets/1/darkcal": start + 1}, BootId: bootId()},
+		"other boot":  {Starts: map[string]uint64{"sockets/1/darkcal": start}, BootId: "another-boot"},
+		"no start":    {BootId: bootId()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := fakeConfig(t)
+			config.StateDir = t.TempDir()
+			dark := newSocketObject("1")
+			dark.Darkcal.ProcessStatus = runningStatus()
+			snap.Sockets = []SocketObject{*dark}
+			snap.Pids = map[string]int{"sockets/1/darkcal": pid}
+			if err := NewStateStore(config.StateDir).save(snap); err != nil {
+				t.Fatal(err)
+			}
+
+			_, st := newTestState(t, config)
+			if ps := waitApp(t, st, darkcalApp, "1"); ps.CompletionStatus != CompletionOrphaned {
+				t.Errorf("darkcal got %+v", ps)
+			}
+			if st.Processes.Get("sockets/1/darkcal") != nil {
+				t.Error("pid was adopted")
+			}
+			if !alive(pid) {
+				t.Error("process is gone")
+			}
+		})
+	}
+}
+
+func TestRestartCountsAdoptedPostprimaryAsRunning(t *testing.T) {
+	config := fakeConfig(t)
+	config.StateDir = t.TempDir()
+	config.MaxPostprimaries = 1
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	prefix := "file:" + filepath.Join(t.TempDir(), "m2")
+	snap := snapshot{
+		Postprimaries: []PostprimaryObject{
+			{Mid: "m1", ProcessStatus: runningStatus()},
+			{Mid: "m2", BazFileUrl: "file:/data/m2.baz", OutputPrefixUrl: prefix, ProcessStatus: readyStatus()},
+		},
+		Pids:   map[string]int{"postprimaries/m1": cmd.Process.Pid},
+		Starts: map[string]uint64{"postprimaries/m1": processStart(cmd.Process.Pid)},
+		BootId: bootId(),
+	}
+	if err := NewStateStore(config.StateDir).save(snap); err != nil {
+		t.Fatal(err)
+	}
+
+	_, st := newTestState(t, config)
+	time.Sleep(100 * time.Millisecond)
+	if obj, _ := st.Postprimaries.Get("m2"); obj.ProcessStatus.ExecutionStatus != Ready ||
+		obj.PostprimaryStatus.QueuePosition != 1 {
+		t.Errorf("m2 did not wait for the adopted m1: %+v", obj)
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+	if ps := waitPostprimary(t, st, "m1").ProcessStatus; ps.CompletionStatus != CompletionOrphaned {
+		t.Errorf("m1 got %+v", ps)
+	}
+	if ps := waitPostprimary(t, st, "m2").ProcessStatus; ps.CompletionStatus != CompletionSuccess {
+		t.Errorf("m2 got %+v", ps)
+	}
+}
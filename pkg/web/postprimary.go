@@ -0,0 +1,475 @@
+package web
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrPostprimaryExists is returned when a MID already has a postprimary.
+	ErrPostprimaryExists = errors.New("postprimary already exists")
+
+	// ErrPostprimaryNotFound is returned for a MID without a postprimary.
+	ErrPostprimaryNotFound = errors.New("postprimary not found")
+
+	// ErrPostprimaryRunning is returned when deleting a postprimary that has not been stopped.
+	ErrPostprimaryRunning = errors.New("postprimary is running")
+)
+
+// PostprimaryRegistry holds the postprimary objects, keyed by MID, and
+// the jobs that run them.
+type PostprimaryRegistry struct {
+	mu   sync.RWMutex
+	objs map[string]*PostprimaryObject
+	jobs map[string]*postprimaryJob
+
+	// Called after every change, without the lock
+	onChange func()
+
+	// Where changes in status and progress are published, and final
+	// objects sent, or nil
+	events   *EventLog
+	webhooks *Webhooks
+}
+
+// NewPostprimaryRegistry returns an empty registry.
+func NewPostprimaryRegistry() *PostprimaryRegistry {
+	return &PostprimaryRegistry{
+		objs: make(map[string]*PostprimaryObject),
+		jobs: make(map[string]*postprimaryJob),
+	}
+}
+
+// Mids returns the MIDs of all postprimaries, sorted.
+func (r *PostprimaryRegistry) Mids() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	mids := make([]string, 0, len(r.objs))
+	for mid := range r.objs {
+		mids = append(mids, mid)
+	}
+	sort.Strings(mids)
+	return mids
+}
+
+// Get returns a copy of the postprimary object for mid.
+func (r *PostprimaryRegistry) Get(mid string) (PostprimaryObject, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	obj, ok := r.objs[mid]
+	if !ok {
+		return PostprimaryObject{}, false
+	}
+	return *obj, true
+}
+
+// Update calls f on the postprimary object for mid while holding the write lock.
+func (r *PostprimaryRegistry) Update(mid string, f func(*PostprimaryObject)) error {
+	return r.update(mid, nil, f)
+}
+
+// Calls f on the postprimary object of job, as long as job is still
+// the one for its MID; one that was deleted keeps to itself, even if
+// the MID is posted again.
+func (r *PostprimaryRegistry) updateJob(job *postprimaryJob, f func(*PostprimaryObject)) error {
+	return r.update(job.mid, job, f)
+}
+
+func (r *PostprimaryRegistry) update(mid string, job *postprimaryJob, f func(*PostprimaryObject)) error {
+	var c changes
+	defer r.changed(&c)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	obj, ok := r.objs[mid]
+	if !ok || (job != nil && r.jobs[mid] != job) {
+		return ErrPostprimaryNotFound
+	}
+	before := *obj
+	f(obj)
+	c.postprimary(before, *obj)
+	return nil
+}
+
+func (r *PostprimaryRegistry) add(obj PostprimaryObject, job *postprimaryJob) error {
+	var c changes
+	defer r.changed(&c)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.objs[obj.Mid]; ok {
+		return ErrPostprimaryExists
+	}
+	r.objs[obj.Mid] = &obj
+	r.jobs[obj.Mid] = job
+	// No webhook: an obj restored as COMPLETE had its callback before.
+	c.events = postprimaryEvents(PostprimaryObject{}, obj)
+	return nil
+}
+
+func (r *PostprimaryRegistry) job(mid string) *postprimaryJob {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.jobs[mid]
+}
+
+// Publishes the changes, if any, and calls onChange.
+func (r *PostprimaryRegistry) changed(c *changes) {
+	c.publish(r.events, r.webhooks)
+	if r.onChange != nil {
+		r.onChange()
+	}
+}
+
+// Delete drops the postprimary for mid, unless it is running and not forced.
+func (r *PostprimaryRegistry) Delete(mid string, force bool) (PostprimaryObject, error) {
+	defer r.changed(nil)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	obj, ok := r.objs[mid]
+	if !ok {
+		return PostprimaryObject{}, ErrPostprimaryNotFound
+	}
+	if obj.ProcessStatus.ExecutionStatus == Running && !force {
+		return PostprimaryObject{}, ErrPostprimaryRunning
+	}
+	delete(r.objs, mid)
+	delete(r.jobs, mid)
+	return *obj, nil
+}
+
+// Deletes the postprimary for mid. A queued job is taken out of the
+// queue. A running one is refused, unless force, which stops it and
+// waits for it to finish first, so that the MID is free to post again.
+func (st *State) deletePostprimary(mid string, force bool) (PostprimaryObject, error) {
+	if job := st.Queue.cancel(mid); job != nil {
+		job.cleanup()
+	} else if obj, ok := st.Postprimaries.Get(mid); ok && force && obj.ProcessStatus.ExecutionStatus == Running {
+		if job := st.Postprimaries.job(mid); job != nil {
+			job.stop()
+			select {
+			case <-job.done:
+			case <-st.done:
+			}
+		}
+	}
+	return st.Postprimaries.Delete(mid, force)
+}
+
+// DeleteResultObject reports what became of one postprimary in a bulk delete.
+type DeleteResultObject struct {
+	Mid     string `json:"mid"`
+	Deleted bool   `json:"deleted"`
+
+	// Why it was not deleted
+	Reason string `json:"reason,omitempty"`
+}
+
+// postprimaryFilter selects postprimaries for a bulk delete. Zero
+// values match everything.
+type postprimaryFilter struct {
+	status    ExecutionStatusEnum
+	olderThan time.Duration
+	midPrefix string
+}
+
+func (f postprimaryFilter) matches(obj PostprimaryObject, now time.Time) bool {
+	if f.status != "" && obj.ProcessStatus.ExecutionStatus != f.status {
+		return false
+	}
+	if !strings.HasPrefix(obj.Mid, f.midPrefix) {
+		return false
+	}
+	if f.olderThan > 0 {
+		t, err := time.Parse(time.RFC3339, obj.ProcessStatus.Timestamp)
+		if err != nil || now.Sub(t) < f.olderThan {
+			return false
+		}
+	}
+	return true
+}
+
+// A step of a postprimary job, i.e. one child process.
+type step struct {
+	path string
+	args []string
+}
+
+// postprimaryJob runs baz2bam, then optionally ccs, for one movie.
+type postprimaryJob struct {
+	mid   string
+	steps []step
+	state *State
+
+	// Closed once the job is finished, if it ever ran
+	done chan struct{}
+
+	// OutputPrefixUrl, and the local path it resolves to
+	urlPrefix, prefix string
+
+	// Removed once the job is done
+	tmpfiles []string
+
+	tracker *progressTracker
+
+	// Where the output of every step goes, for the tracker to follow,
+	// and the level kept of it. The log is open while the job runs.
+	logFile  string
+	logLevel LogLevelEnum
+	ownLog   bool // in Config.LogDir, not at a logUrl
+	log      *logFile
+
+	mu      sync.Mutex
+	stopped bool
+}
+
+// Prepares the job for obj, resolving its URLs and writing the
+// subreadset metadata to a temporary file for baz2bam.
+func (st *State) newPostprimaryJob(obj PostprimaryObject) (*postprimaryJob, error) {
+	job := &postprimaryJob{
+		mid:       obj.Mid,
+		state:     st,
+		done:      make(chan struct{}),
+		urlPrefix: obj.OutputPrefixUrl,
+	}
+	baz, err := st.Resolver.Path(obj.BazFileUrl)
+	if err != nil {
+		return nil, err
+	}
+	job.prefix, err = st.Resolver.Path(obj.OutputPrefixUrl)
+	if err != nil {
+		return nil, err
+	}
+	job.logFile, err = st.postprimaryLogPath(obj)
+	if err != nil {
+		return nil, err
+	}
+	job.logLevel = obj.LogLevel
+	job.ownLog = obj.LogUrl == ""
+
+	c := cmdline{resolver: st.Resolver}
+	c.add("-o", job.prefix)
+	c.add("--chiplayout", obj.Chiplayout)
+	c.addPath("--statsxml", obj.OutputStatsXmlUrl)
+	c.addPath("--statsh5", obj.OutputStatsH5Url)
+	c.addPath("--rstatsh5", obj.OutputReduceStatsH5Url)
+	if obj.IncludeKinetics {
+		c.args = append(c.args, "--kinetics")
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	if obj.SubreadsetMetadataXml != "" {
+		f, err := os.CreateTemp("", obj.Mid+".*.metadata.xml")
+		if err != nil {
+			return nil, err
+		}
+		job.tmpfiles = append(job.tmpfiles, f.Name())
+		_, err = f.WriteString(obj.SubreadsetMetadataXml)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			job.cleanup()
+			return nil, err
+		}
+		c.add("--metadata", f.Name())
+	}
+	c.args = append(c.args, baz)
+	job.steps = append(job.steps, step{st.Config.Baz2bam, c.args})
+
+	if obj.CcsOnInstrument {
+		job.steps = append(job.steps, step{st.Config.Ccs, []string{
+			job.prefix + ".subreads.bam",
+			job.prefix + ".ccs.bam",
+			"--report-file", job.prefix + ".ccs_report.txt",
+		}})
+	}
+	job.tracker = newProgressTracker(len(job.steps))
+	return job, nil
+}
+
+func (job *postprimaryJob) key() string {
+	return "postprimaries/" + job.mid
+}
+
+// Starts the first step, and runs the rest of the job in the background.
+func (job *postprimaryJob) start() error {
+	job.log = job.openLog()
+	p, err := job.startStep(job.steps[0])
+	if err != nil {
+		return err
+	}
+	go job.run(p)
+	return nil
+}
+
+func (job *postprimaryJob) startStep(s step) (*Process, error) {
+	onChange := func(status ProcessStatusObject) {
+		// Only the job knows when it is COMPLETE.
+		if status.ExecutionStatus == Complete {
+			return
+		}
+		job.state.Postprimaries.updateJob(job, func(obj *PostprimaryObject) {
+			obj.ProcessStatus = status
+		})
+	}
+	return job.state.Processes.Start(job.key(), s.path, s.args, job.log.output(), onChange)
+}
+
+// Opens the log of the job, for the tracker to follow. Without a log
+// to keep, or if it cannot be opened, a temporary one stands in,
+// rotated without copies. Failing that too, the job runs without
+// progress; like newLog, that is only logged.
+func (job *postprimaryJob) openLog() *logFile {
+	st := job.state
+	l := st.newLog(job.logFile, job.logLevel, job.ownLog)
+	if l == nil {
+		f, err := os.CreateTemp("", job.mid+".*.log")
+		if err != nil {
+			log.Printf("log: %v", err)
+			return nil
+		}
+		f.Close()
+		job.tmpfiles = append(job.tmpfiles, f.Name())
+		if l, err = reopenLog(f.Name(), Debug, int64(st.Config.LogRotateSizeMb)<<20, 0); err != nil {
+			log.Printf("log: %v", err)
+			return nil
+		}
+	}
+	l.follow = job.tracker
+	return l
+}
+
+// Waits for each step in turn, registering outputs and progress as
+// they appear.
+func (job *postprimaryJob) run(p *Process) {
+	ticker := time.NewTicker(job.state.Config.OutputPollInterval)
+	defer ticker.Stop()
+	i := 0
+	for {
+		select {
+		case <-ticker.C:
+			job.log.poll()
+			job.tracker.sampleRss(p.Pid())
+			job.refresh()
+			continue
+		case <-p.Done():
+			job.log.poll()
+		case <-job.state.done:
+			// The step keeps running, for the next pa-ws to adopt.
+			job.log.release()
+			return
+		}
+		status := p.Status()
+		i++
+		if status.CompletionStatus != CompletionSuccess || i == len(job.steps) {
+			job.finish(status)
+			return
+		}
+		if job.isStopped() {
+			status.CompletionStatus = CompletionAborted
+			job.finish(status)
+			return
+		}
+		job.tracker.nextStep()
+		var err error
+		if p, err = job.startStep(job.steps[i]); err != nil {
+			job.finish(failedStatus())
+			return
+		}
+		if job.isStopped() {
+			// Stopped while the step was starting.
+			job.state.stopProcess(job.key())
+		}
+	}
+}
+
+// Records the final status of the job, its outputs and progress.
+func (job *postprimaryJob) finish(status ProcessStatusObject) {
+	// Hands the tracker the rest of the output first.
+	job.log.Close()
+	job.cleanup()
+	if status.CompletionStatus == CompletionSuccess {
+		job.tracker.complete()
+	}
+	job.refresh()
+	status.ExecutionStatus = Complete
+	status.Timestamp = timestamp(time.Now())
+	job.state.Postprimaries.updateJob(job, func(obj *PostprimaryObject) {
+		obj.ProcessStatus = status
+	})
+	job.state.Queue.done(job, job.tracker.peakRssGb())
+	close(job.done)
+}
+
+func (job *postprimaryJob) cleanup() {
+	for _, name := range job.tmpfiles {
+		os.Remove(name)
+	}
+	job.tmpfiles = nil
+}
+
+// Registers the latest progress, and the files that start with the
+// output prefix.
+func (job *postprimaryJob) refresh() {
+	dir, base := filepath.Split(job.prefix)
+	entries, err := os.ReadDir(dir)
+	urls := []string{}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), base) {
+			urls = append(urls, job.urlPrefix+strings.TrimPrefix(e.Name(), base))
+		}
+	}
+	job.state.Postprimaries.updateJob(job, func(obj *PostprimaryObject) {
+		if err == nil {
+			obj.PostprimaryStatus.OutputUrls = urls
+		}
+		job.tracker.update(&obj.PostprimaryStatus)
+	})
+}
+
+// Stops the current step, and keeps later ones from starting. A job
+// still in the queue never starts.
+func (job *postprimaryJob) stop() {
+	if job.state.Queue.cancel(job.mid) != nil {
+		status := ProcessStatusObject{CompletionStatus: CompletionAborted}
+		job.finish(status)
+		return
+	}
+	job.mu.Lock()
+	job.stopped = true
+	job.mu.Unlock()
+	job.state.stopProcess(job.key())
+}
+
+func (job *postprimaryJob) isStopped() bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.stopped
+}
+
+// Whether the postprimary reads or writes the storage of mid.
+func (obj *PostprimaryObject) uses(mid string) bool {
+	return obj.Mid == mid || inStorage(mid, obj.urls())
+}
+
+// The URLs the postprimary reads or writes.
+func (obj *PostprimaryObject) urls() []string {
+	return []string{obj.BazFileUrl, obj.OutputPrefixUrl, obj.OutputStatsXmlUrl,
+		obj.OutputStatsH5Url, obj.OutputReduceStatsH5Url, obj.LogUrl}
+}
+
+// Whether any of urls is in the storage of mid.
+func inStorage(mid string, urls []string) bool {
+	for _, url := range urls {
+		if strings.Contains(url, "/storages/"+mid+"/") {
+			return true
+		}
+	}
+	return false
+}
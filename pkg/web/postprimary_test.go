@@ -0,0 +1,210 @@
+package web
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Polls until the postprimary for mid is COMPLETE.
+func waitPostprimary(t *testing.T, st *State, mid string) PostprimaryObject {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		obj, _ := st.Postprimaries.Get(mid)
+		if obj.ProcessStatus.ExecutionStatus == Complete {
+			return obj
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("postprimary never completed")
+	return PostprimaryObject{}
+}
+
+// Creates storage for mid, returning its directory.
+func newTestStorage(t *testing.T, router *gin.Engine, mid string) string {
+	t.Helper()
+	var obj StorageObject
+	decode(t, serve(router, "POST", "/storages", `{"mid": "`+mid+`"}`), &obj)
+	return strings.TrimPrefix(obj.LinuxPath, "file:")
+}
+
+const postprimaryBody = `{
+	"mid": "m1",
+	"bazFileUrl": "http://localhost:23632/storages/m1/m1.baz",
+	"outputPrefixUrl": "http://localhost:23632/storages/m1/m1",
+	"subreadsetMetadataXml": "<SubreadSets/>",
+	"includeKinetics": true,
+	"ccsOnInstrument": true
+}`
+
+func TestStartPostprimary(t *testing.T) {
+	argsFile := filepath.Join(t.TempDir(), "args")
+	t.Setenv("FAKE_ARGS", argsFile)
+	router, st := newTestState(t, fakeConfig(t))
+	dir := newTestStorage(t, router, "m1")
+	t.Setenv("FAKE_OUTPUTS", filepath.Join(dir, "m1.subreads.bam")+" "+filepath.Join(dir, "m1.baz2bam.log"))
+
+	w := serve(router, "POST", "/postprimaries", postprimaryBody)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve(router, "POST", "/postprimaries", postprimaryBody); w.Code != http.StatusConflict {
+		t.Errorf("duplicate: got %d", w.Code)
+	}
+	obj := waitPostprimary(t, st, "m1")
+	if obj.ProcessStatus.CompletionStatus != CompletionSuccess {
+		t.Errorf("got %+v", obj.ProcessStatus)
+	}
+	want := []string{
+		"http://localhost:23632/storages/m1/m1.baz2bam.log",
+		"http://localhost:23632/storages/m1/m1.subreads.bam",
+	}
+	if !reflect.DeepEqual(obj.PostprimaryStatus.OutputUrls, want) {
+		t.Errorf("got %v", obj.PostprimaryStatus.OutputUrls)
+	}
+
+	b, _ := os.ReadFile(argsFile)
+	args := strings.Split(strings.TrimSpace(string(b)), "\n")
+	prefix := filepath.Join(dir, "m1")
+	if args[0] != "-o" || args[1] != prefix {
+		t.Errorf("baz2bam got %v", args)
+	}
+	var metadata string
+	for i, arg := range args {
+		if arg == "--metadata" {
+			metadata = args[i+1]
+		}
+	}
+	if metadata == "" {
+		t.Errorf("no --metadata in %v", args)
+	} else if _, err := os.Stat(metadata); !os.IsNotExist(err) {
+		t.Errorf("%s left behind", metadata)
+	}
+	if !strings.Contains(string(b), "--kinetics\n") || !strings.Contains(string(b), filepath.Join(dir, "m1.baz")+"\n"+prefix+".subreads.bam\n") {
+		t.Errorf("expected baz2bam then ccs, got\n%s", b)
+	}
+
+	var mids []string
+	decode(t, serve(router, "GET", "/postprimaries", ""), &mids)
+	if !reflect.DeepEqual(mids, []string{"m1"}) {
+		t.Errorf("got %v", mids)
+	}
+	if w := serve(router, "GET", "/postprimaries/m2", ""); w.Code != http.StatusNotFound {
+		t.Errorf("got %d", w.Code)
+	}
+}
+
+func TestStopPostprimary(t *testing.T) {
+	argsFile := filepath.Join(t.TempDir(), "args")
+	t.Setenv("FAKE_ARGS", argsFile)
+	t.Setenv("FAKE_SLEEP", "10")
+	router, st := newTestState(t, fakeConfig(t))
+	newTestStorage(t, router, "m1")
+	serve(router, "POST", "/postprimaries", postprimaryBody)
+
+	if w := serve(router, "POST", "/storages/m1/free", ""); w.Code != http.StatusConflict {
+		t.Errorf("free while running: got %d", w.Code)
+	}
+	if w := serve(router, "DELETE", "/postprimaries/m1", ""); w.Code != http.StatusConflict {
+		t.Errorf("delete while running: got %d", w.Code)
+	}
+	if w := serve(router, "POST", "/postprimaries/m1/stop", ""); w.Code != http.StatusOK {
+		t.Errorf("got %d", w.Code)
+	}
+	if obj := waitPostprimary(t, st, "m1"); obj.ProcessStatus.CompletionStatus != CompletionAborted {
+		t.Errorf("got %+v", obj.ProcessStatus)
+	}
+	if b, _ := os.ReadFile(argsFile); strings.Contains(string(b), "--report-file") {
+		t.Errorf("ccs ran after stop:\n%s", b)
+	}
+	if w := serve(router, "DELETE", "/postprimaries/m1", ""); w.Code != http.StatusOK {
+		t.Errorf("got %d", w.Code)
+	}
+	if w := serve(router, "GET", "/postprimaries/m1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("got %d", w.Code)
+	}
+}
+
+func TestForceDeleteThenPostAgain(t *testing.T) {
+	t.Setenv("FAKE_SLEEP", "10")
+	router, st := newTestState(t, fakeConfig(t))
+	newTestStorage(t, router, "m1")
+	serve(router, "POST", "/postprimaries", postprimaryBody)
+	waitRunning(t, st, "m1")
+
+	var results []DeleteResultObject
+	decode(t, serve(router, "DELETE", "/postprimaries?midPrefix=m1&force=true", ""), &results)
+	if len(results) != 1 || !results[0].Deleted {
+		t.Fatalf("got %+v", results)
+	}
+
+	// The stopped job is done with the MID, and leaves the new one be.
+	t.Setenv("FAKE_SLEEP", "")
+	if w := serve(router, "POST", "/postprimaries", postprimaryBody); w.Code != http.StatusOK {
+		t.Fatalf("post again: got %d: %s", w.Code, w.Body.String())
+	}
+	if obj := waitPostprimary(t, st, "m1"); obj.ProcessStatus.CompletionStatus != CompletionSuccess {
+		t.Errorf("got %+v", obj.ProcessStatus)
+	}
+}
+
+func TestStartPostprimaryBadRequest(t *testing.T) {
+	router := newTestRouter(t, fakeConfig(t))
+	for _, body := range []string{
+		`{"mid": "m1", "outputPrefixUrl": "http://localhost:23632/storages/m1/m1"}`,
+		`{"mid": "m1", "bazFileUrl": "http://localhost:23632/storages/m1/m1.baz", "outputPrefixUrl": "http://localhost:23632/storages/m1/m1"}`,
+		`{"mid": "../m1", "bazFileUrl": "file:/x.baz", "outputPrefixUrl": "file:/x"}`,
+	} {
+		if w := serve(router, "POST", "/postprimaries", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d", body, w.Code)
+		}
+	}
+}
+
+func TestDeletePostprimaries(t *testing.T) {
+	router, st := newTestState(t, fakeConfig(t))
+	old := timestamp(time.Now().Add(-2 * time.Hour))
+	for _, obj := range []PostprimaryObject{
+		{Mid: "m1", ProcessStatus: ProcessStatusObject{ExecutionStatus: Complete, Timestamp: old}},
+		{Mid: "m2", ProcessStatus: ProcessStatusObject{ExecutionStatus: Complete, Timestamp: timestamp(time.Now())}},
+		{Mid: "m3", ProcessStatus: ProcessStatusObject{ExecutionStatus: Running, Timestamp: old}},
+		{Mid: "x1", ProcessStatus: ProcessStatusObject{ExecutionStatus: Complete, Timestamp: old}},
+	} {
+		if err := st.Postprimaries.add(obj, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, query := range []string{"?status=DONE", "?olderThan=1day", "?force=maybe"} {
+		if w := serve(router, "DELETE", "/postprimaries"+query, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d", query, w.Code)
+		}
+	}
+
+	var results []DeleteResultObject
+	decode(t, serve(router, "DELETE", "/postprimaries?midPrefix=m&olderThan=1h", ""), &results)
+	want := []DeleteResultObject{
+		{Mid: "m1", Deleted: true},
+		{Mid: "m3", Deleted: false, Reason: ErrPostprimaryRunning.Error()},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %+v", results)
+	}
+
+	decode(t, serve(router, "DELETE", "/postprimaries?status=RUNNING&force=true", ""), &results)
+	if !reflect.DeepEqual(results, []DeleteResultObject{{Mid: "m3", Deleted: true}}) {
+		t.Errorf("got %+v", results)
+	}
+
+	var mids []string
+	decode(t, serve(router, "GET", "/postprimaries", ""), &mids)
+	if !reflect.DeepEqual(mids, []string{"m2", "x1"}) {
+		t.Errorf("got %v", mids)
+	}
+}
@@ -0,0 +1,415 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Values for ProcessStatusObject.CompletionStatus
+const (
+	CompletionSuccess = "SUCCESS"
+	CompletionFailed  = "FAILED"
+	CompletionAborted = "ABORTED"
+
+	// Started before pa-ws restarted, and finished while or after it
+	// did, so there is no exit code to go by.
+	CompletionOrphaned = "ORPHANED"
+)
+
+// ErrProcessRunning is returned when a second process is started under the same key.
+var ErrProcessRunning = errors.New("process is already running")
+
+// Status of a process that could not be started at all.
+func failedStatus() ProcessStatusObject {
+	return ProcessStatusObject{
+		ExecutionStatus:  Complete,
+		CompletionStatus: CompletionFailed,
+		Timestamp:        timestamp(time.Now()),
+		ExitCode:         -1,
+	}
+}
+
+// Process is a child process launched by pa-ws.
+type Process struct {
+	cmd      *exec.Cmd // nil if adopted
+	pid      int
+	start    uint64 // see processStart
+	done     chan struct{}
+	onChange func(ProcessStatusObject)
+
+	mu       sync.Mutex
+	status   ProcessStatusObject
+	stopping bool
+}
+
+// StartProcess launches the executable and returns once it is RUNNING.
+// Its stdout and stderr both go to output, unless that is nil. That is
+// a file, not a pipe to pa-ws, so the child can outlive pa-ws.
+// onChange receives every status update, the last one (COMPLETE) from
+// the goroutine that waits on the child.
+//
+// The child leads its own process group, so that stopping it reaches
+// anything it started too.
+func StartProcess(path string, args []string, output *os.File, onChange func(ProcessStatusObject)) (*Process, error) {
+	p := &Process{
+		cmd:      exec.Command(path, args...),
+		done:     make(chan struct{}),
+		onChange: onChange,
+	}
+	p.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if output != nil {
+		p.cmd.Stdout = output
+		p.cmd.Stderr = output
+	}
+	if err := p.cmd.Start(); err != nil {
+		return nil, err
+	}
+	p.pid = p.cmd.Process.Pid
+	p.start = processStart(p.pid)
+	p.setStatus(ProcessStatusObject{ExecutionStatus: Running})
+	go p.wait()
+	return p, nil
+}
+
+func (p *Process) wait() {
+	err := p.cmd.Wait()
+	status := ProcessStatusObject{
+		ExecutionStatus:  Complete,
+		CompletionStatus: CompletionSuccess,
+		ExitCode:         int32(p.cmd.ProcessState.ExitCode()),
+	}
+	if p.isStopping() {
+		status.CompletionStatus = CompletionAborted
+	} else if err != nil {
+		status.CompletionStatus = CompletionFailed
+	}
+	p.setStatus(status)
+	close(p.done)
+}
+
+// Adopts a process that an earlier pa-ws started, and that is still
+// running. It is not our child, so there is no waiting on it; instead
+// it is polled until it is gone, or until quit is closed.
+func adoptProcess(pid int, start uint64, interval time.Duration, quit <-chan struct{}, onChange func(ProcessStatusObject)) *Process {
+	p := &Process{
+		pid:      pid,
+		start:    start,
+		done:     make(chan struct{}),
+		onChange: onChange,
+		status: ProcessStatusObject{
+			ExecutionStatus: Running,
+			Timestamp:       timestamp(time.Now()),
+		},
+	}
+	go p.poll(interval, quit)
+	return p
+}
+
+func (p *Process) poll(interval time.Duration, quit <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for sameProcess(p.pid, p.start) {
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+	status := ProcessStatusObject{
+		ExecutionStatus:  Complete,
+		CompletionStatus: CompletionOrphaned,
+		ExitCode:         -1,
+	}
+	if p.isStopping() {
+		status.CompletionStatus = CompletionAborted
+	}
+	p.setStatus(status)
+	close(p.done)
+}
+
+// Whether there is a process pid, ours or not.
+func alive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// Start time of process pid, in clock ticks since boot (field 22 of
+// /proc/<pid>/stat), or 0 if there is no such process. Unlike the pid,
+// it tells a process apart from a later one that got the same pid.
+func processStart(pid int) uint64 {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0
+	}
+	// Fields start after the command name, which may hold ')' itself
+	fields := strings.Fields(string(b[bytes.LastIndexByte(b, ')')+1:]))
+	if len(fields) < 20 {
+		return 0
+	}
+	start, _ := strconv.ParseUint(fields[19], 10, 64)
+	return start
+}
+
+// Whether pid is still the process that started at start.
+func sameProcess(pid int, start uint64) bool {
+	return pid > 0 && start != 0 && alive(pid) && processStart(pid) == start
+}
+
+// Identifies the current boot; pids and start times of another boot
+// mean nothing in this one.
+func bootId() string {
+	b, _ := os.ReadFile("/proc/sys/kernel/random/boot_id")
+	return strings.TrimSpace(string(b))
+}
+
+func (p *Process) setStatus(status ProcessStatusObject) {
+	status.Timestamp = timestamp(time.Now())
+	p.mu.Lock()
+	p.status = status
+	p.mu.Unlock()
+	if p.onChange != nil {
+		p.onChange(status)
+	}
+}
+
+// Status returns the latest status of the process.
+func (p *Process) Status() ProcessStatusObject {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+// Pid of the child.
+func (p *Process) Pid() int {
+	return p.pid
+}
+
+// Done is closed once the child has exited and its final status is recorded.
+func (p *Process) Done() <-chan struct{} {
+	return p.done
+}
+
+// Stop sends sig to the child, and SIGKILL if it is still around after
+// grace. It returns immediately; the child is done when Done is closed.
+// Stopping a process a second time, or after it exited, does nothing.
+func (p *Process) Stop(sig syscall.Signal, grace time.Duration) {
+	p.mu.Lock()
+	if p.stopping || p.status.ExecutionStatus == Complete {
+		p.mu.Unlock()
+		return
+	}
+	p.stopping = true
+	p.mu.Unlock()
+
+	syscall.Kill(-p.Pid(), sig)
+	go func() {
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-p.done:
+		case <-timer.C:
+			syscall.Kill(-p.Pid(), syscall.SIGKILL)
+		}
+	}()
+}
+
+func (p *Process) isStopping() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stopping
+}
+
+// Whether the child has exited. It has as soon as its final status is
+// set, before that is reported, so that whoever hears of it can start
+// the next process under its key.
+func (p *Process) exited() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status.ExecutionStatus == Complete
+}
+
+// Supervisor keeps track of the child processes, at most one running per key,
+// e.g. "sockets/1/basecaller".
+type Supervisor struct {
+	mu    sync.Mutex
+	procs map[string]*Process
+
+	// Keys claimed for a process that is yet to start; see Claim
+	claims map[string]*claim
+
+	// Closed to stop polling adopted processes
+	quit      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSupervisor returns a Supervisor with no processes.
+func NewSupervisor() *Supervisor {
+	return &Supervisor{
+		procs:  make(map[string]*Process),
+		claims: make(map[string]*claim),
+		quit:   make(chan struct{}),
+	}
+}
+
+// The starts that claim a key, and the stop that came in meanwhile
+type claim struct {
+	starts  int
+	stopped bool
+	sig     syscall.Signal
+	grace   time.Duration
+}
+
+// Close stops polling the adopted processes, which are then never done.
+// Children keep running, and are waited on until they exit.
+func (s *Supervisor) Close() {
+	s.closeOnce.Do(func() { close(s.quit) })
+}
+
+// Claim tells that a process is about to start under key. Stops that
+// come in until then are held, and the process is stopped as soon as
+// Start launches it. Unclaim drops the claim, if it never starts.
+func (s *Supervisor) Claim(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.claims[key] == nil {
+		s.claims[key] = &claim{}
+	}
+	s.claims[key].starts++
+}
+
+// Unclaim drops a claim on key, and the stop held with it once no
+// other start claims key.
+func (s *Supervisor) Unclaim(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c := s.claims[key]; c != nil {
+		if c.starts--; c.starts <= 0 {
+			delete(s.claims, key)
+		}
+	}
+}
+
+// Start launches a process under key, unless one is still running there.
+// It settles any claim on key; see Claim.
+func (s *Supervisor) Start(key, path string, args []string, output *os.File, onChange func(ProcessStatusObject)) (*Process, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.claims[key]
+	delete(s.claims, key)
+	if p, ok := s.procs[key]; ok && !p.exited() {
+		return nil, ErrProcessRunning
+	}
+	p, err := StartProcess(path, args, output, onChange)
+	if err != nil {
+		return nil, err
+	}
+	s.procs[key] = p
+	if c != nil && c.stopped {
+		p.Stop(c.sig, c.grace)
+	}
+	return p, nil
+}
+
+// Adopt tracks pid under key, as if it had been started there. See
+// adoptProcess.
+func (s *Supervisor) Adopt(key string, pid int, start uint64, interval time.Duration, onChange func(ProcessStatusObject)) *Process {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := adoptProcess(pid, start, interval, s.quit, onChange)
+	s.procs[key] = p
+	return p
+}
+
+// Pids returns the pid of every running process, by key.
+func (s *Supervisor) Pids() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pids := make(map[string]int)
+	for key, p := range s.procs {
+		if !p.exited() {
+			pids[key] = p.pid
+		}
+	}
+	return pids
+}
+
+// Starts returns the start time of every running process, by key. See
+// processStart.
+func (s *Supervisor) Starts() map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	starts := make(map[string]uint64)
+	for key, p := range s.procs {
+		if !p.exited() {
+			starts[key] = p.start
+		}
+	}
+	return starts
+}
+
+// Get returns the latest process started under key, or nil.
+func (s *Supervisor) Get(key string) *Process {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.procs[key]
+}
+
+// Running returns the keys of the processes still running, sorted.
+func (s *Supervisor) Running() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key, p := range s.procs {
+		if !p.exited() {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Stop stops the process under key, if any, and the one about to start
+// there if key is claimed. It returns false if nothing was ever started
+// or claimed there.
+func (s *Supervisor) Stop(key string, sig syscall.Signal, grace time.Duration) bool {
+	s.mu.Lock()
+	c := s.claims[key]
+	if c != nil && !c.stopped {
+		c.stopped, c.sig, c.grace = true, sig, grace
+	}
+	p := s.procs[key]
+	s.mu.Unlock()
+	if p == nil {
+		return c != nil
+	}
+	p.Stop(sig, grace)
+	return true
+}
+
+var signals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// ParseSignal accepts a signal name like "SIGTERM".
+func ParseSignal(name string) (syscall.Signal, error) {
+	sig, ok := signals[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal %q", name)
+	}
+	return sig, nil
+}
@@ -0,0 +1,235 @@
+package web
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func fakeConfig(t *testing.T) Config {
+	t.Helper()
+	fake, err := filepath.Abs("testdata/fake_process.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := testConfig(t)
+	config.SmrtBasecaller = fake
+	config.PaCal = fake
+	config.Baz2bam = fake
+	config.Ccs = fake
+	config.OutputPollInterval = 10 * time.Millisecond
+	return config
+}
+
+// Polls until the basecaller on socket id is COMPLETE.
+func waitBasecaller(t *testing.T, st *State, id string) SocketBasecallerObject {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s, _ := st.Sockets.Get(id)
+		if s.Basecaller.ProcessStatus.ExecutionStatus == Complete {
+			return s.Basecaller
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("basecaller never completed")
+	return SocketBasecallerObject{}
+}
+
+const basecallerBody = `{
+	"mid": "m123456_987654",
+	"bazUrl": "file:/data/pa/m123456_987654/thefile.baz",
+	"traceFileUrl": "discard:",
+	"chiplayout": "Minesweeper1.0",
+	"sequencingRoi": [0, 0, 2048, 1980],
+	"analogs": [{"baseLabel": "A", "relativeAmp": 1}],
+	"expectedFrameRate": 100
+}`
+
+func TestStartBasecaller(t *testing.T) {
+	argsFile := filepath.Join(t.TempDir(), "args")
+	t.Setenv("FAKE_ARGS", argsFile)
+	router, st := newTestState(t, fakeConfig(t))
+
+	w := serve(router, "POST", "/sockets/1/basecaller/start", basecallerBody)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	obj := waitBasecaller(t, st, "1")
+	if obj.Mid != "m123456_987654" {
+		t.Errorf("got mid %q", obj.Mid)
+	}
+	if ps := obj.ProcessStatus; ps.CompletionStatus != CompletionSuccess || ps.ExitCode != 0 {
+		t.Errorf("got %+v", ps)
+	}
+
+	b, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := string(b)
+	for _, want := range []string{"--outputbazfile\n/data/pa/m123456_987654/thefile.baz\n", "--sequencingroi\n0,0,2048,1980\n", "--framerate\n100\n"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("missing %q in args:\n%s", want, args)
+		}
+	}
+	if strings.Contains(args, "--outputtrcfile") {
+		t.Errorf("discard: trace file was passed on:\n%s", args)
+	}
+}
+
+func TestStartBasecallerFails(t *testing.T) {
+	t.Setenv("FAKE_EXIT", "3")
+	router, st := newTestState(t, fakeConfig(t))
+	serve(router, "POST", "/sockets/2/basecaller/start", basecallerBody)
+	ps := waitBasecaller(t, st, "2").ProcessStatus
+	if ps.CompletionStatus != CompletionFailed || ps.ExitCode != 3 {
+		t.Errorf("got %+v", ps)
+	}
+}
+
+func TestStartBasecallerWhileRunning(t *testing.T) {
+	t.Setenv("FAKE_SLEEP", "0.5")
+	router, st := newTestState(t, fakeConfig(t))
+	serve(router, "POST", "/sockets/1/basecaller/start", basecallerBody)
+	if w := serve(router, "POST", "/sockets/1/basecaller/start", basecallerBody); w.Code != http.StatusConflict {
+		t.Errorf("got %d", w.Code)
+	}
+	waitBasecaller(t, st, "1")
+}
+
+func TestStartBasecallerMissingExecutable(t *testing.T) {
+	config := testConfig(t)
+	config.SmrtBasecaller = filepath.Join(t.TempDir(), "nonesuch")
+	router, st := newTestState(t, config)
+	if w := serve(router, "POST", "/sockets/1/basecaller/start", basecallerBody); w.Code != http.StatusInternalServerError {
+		t.Errorf("got %d", w.Code)
+	}
+	s, _ := st.Sockets.Get("1")
+	if ps := s.Basecaller.ProcessStatus; ps.ExecutionStatus != Complete || ps.CompletionStatus != CompletionFailed {
+		t.Errorf("got %+v", ps)
+	}
+}
+
+func TestStopBasecaller(t *testing.T) {
+	t.Setenv("FAKE_SLEEP", "10")
+	router, st := newTestState(t, fakeConfig(t))
+	serve(router, "POST", "/sockets/1/basecaller/start", basecallerBody)
+	for i := 0; i < 2; i++ {
+		if w := serve(router, "POST", "/sockets/1/basecaller/stop", ""); w.Code != http.StatusOK {
+			t.Fatalf("got %d", w.Code)
+		}
+	}
+	if ps := waitBasecaller(t, st, "1").ProcessStatus; ps.CompletionStatus != CompletionAborted {
+		t.Errorf("got %+v", ps)
+	}
+	// Still fine once it is COMPLETE.
+	if w := serve(router, "POST", "/sockets/1/basecaller/stop", ""); w.Code != http.StatusOK {
+		t.Errorf("got %d", w.Code)
+	}
+}
+
+func TestStopEscalatesToKill(t *testing.T) {
+	t.Setenv("FAKE_SLEEP", "10")
+	t.Setenv("FAKE_TRAP", "1")
+	config := fakeConfig(t)
+	config.StopGracePeriod = 100 * time.Millisecond
+	router, st := newTestState(t, config)
+	serve(router, "POST", "/sockets/1/basecaller/start", basecallerBody)
+	time.Sleep(100 * time.Millisecond) // let the trap be set
+	start := time.Now()
+	serve(router, "POST", "/sockets/1/basecaller/stop", "")
+	if ps := waitBasecaller(t, st, "1").ProcessStatus; ps.CompletionStatus != CompletionAborted {
+		t.Errorf("got %+v", ps)
+	}
+	if elapsed := time.Since(start); elapsed < config.StopGracePeriod {
+		t.Errorf("killed after only %v", elapsed)
+	}
+}
+
+func TestStopUnknownPostprimary(t *testing.T) {
+	router := newTestRouter(t, testConfig(t))
+	if w := serve(router, "POST", "/postprimaries/m1/stop", ""); w.Code != http.StatusNotFound {
+		t.Errorf("got %d", w.Code)
+	}
+}
+
+func TestProcessExitedBeforeComplete(t *testing.T) {
+	s := NewSupervisor()
+	restarted := make(chan error, 1)
+	_, err := s.Start("k", "true", nil, nil, func(status ProcessStatusObject) {
+		if status.ExecutionStatus == Complete {
+			_, err := s.Start("k", "true", nil, nil, nil)
+			restarted <- err
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-restarted:
+		if err != nil {
+			t.Errorf("restart on COMPLETE: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("never completed")
+	}
+}
+
+func TestStartBasecallerRefusedPutsItBack(t *testing.T) {
+	router, st := newTestState(t, fakeConfig(t))
+	// A process left under the key, that the app knows nothing of
+	p, err := st.Processes.Start(basecallerApp.key("1"), "sleep", []string{"10"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop(syscall.SIGKILL, 0)
+	if w := serve(router, "POST", "/sockets/1/basecaller/start", basecallerBody); w.Code != http.StatusConflict {
+		t.Errorf("got %d", w.Code)
+	}
+	s, _ := st.Sockets.Get("1")
+	if s.Basecaller.ProcessStatus.ExecutionStatus != Ready || s.Basecaller.Mid != "" {
+		t.Errorf("got %+v", s.Basecaller)
+	}
+}
+
+func TestStopHeldUntilClaimedStart(t *testing.T) {
+	s := NewSupervisor()
+	s.Claim("k")
+	if !s.Stop("k", syscall.SIGTERM, time.Second) {
+		t.Error("stop of a claimed key did nothing")
+	}
+	p, err := s.Start("k", "sleep", []string{"10"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-p.Done():
+		if ps := p.Status(); ps.CompletionStatus != CompletionAborted {
+			t.Errorf("got %+v", ps)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("held stop never reached the process")
+	}
+
+	// Once the claim is dropped, or the start has settled it, stops no
+	// longer carry over.
+	s.Claim("k")
+	s.Claim("k")
+	s.Stop("k", syscall.SIGTERM, time.Second)
+	s.Unclaim("k")
+	s.Unclaim("k")
+	p, err = s.Start("k", "sleep", []string{"10"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop(syscall.SIGKILL, 0)
+	time.Sleep(100 * time.Millisecond)
+	if p.exited() {
+		t.Errorf("stopped by a dropped claim: %+v", p.Status())
+	}
+}
@@ -0,0 +1,173 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// baz2bam and ccs report progress as lines like
+//
+//	2021-06-25 12:34:56.789 | INFO | Processed 1200000 of 4000000 ZMWs
+//	[2021-06-25T12:34:56] 1200000/4000000 ZMWs
+//
+// The leading timestamp, when there is one, is used for the rates, so
+// a captured log replays to the same numbers.
+var (
+	progressPattern = regexp.MustCompile(`(?i)(\d+)\s*(?:/|of)\s*(\d+)\s+zmws`)
+	logTimePattern  = regexp.MustCompile(`^\[?(\d{4}-\d\d-\d\d[ T]\d\d:\d\d:\d\d(?:\.\d+)?)`)
+)
+
+// progressTracker follows the output of the steps of a postprimary job
+// (baz2bam, then maybe ccs) and keeps the PostprimaryStatusObject
+// numbers up to date. Progress is split evenly between the steps.
+type progressTracker struct {
+	steps int
+
+	mu      sync.Mutex
+	step    int
+	first   progressSample
+	status  PostprimaryStatusObject
+	partial []byte
+}
+
+type progressSample struct {
+	at   time.Time
+	done int64
+}
+
+func newProgressTracker(steps int) *progressTracker {
+	return &progressTracker{steps: steps}
+}
+
+// Write takes output of the current step, as it is read from the log.
+func (t *progressTracker) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		t.line(string(t.partial[:i]), time.Now())
+		t.partial = t.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Handles one line of output. The caller holds the lock.
+func (t *progressTracker) line(s string, now time.Time) {
+	m := progressPattern.FindStringSubmatch(s)
+	if m == nil {
+		return
+	}
+	done, _ := strconv.ParseInt(m[1], 10, 64)
+	total, _ := strconv.ParseInt(m[2], 10, 64)
+	if total <= 0 {
+		return
+	}
+	if tm := logTimePattern.FindStringSubmatch(s); tm != nil {
+		layout := "2006-01-02 15:04:05.999999999"
+		if parsed, err := time.Parse(layout, strings.Replace(tm[1], "T", " ", 1)); err == nil {
+			now = parsed
+		}
+	}
+
+	if t.first.at.IsZero() {
+		t.first = progressSample{now, done}
+	} else if minutes := now.Sub(t.first.at).Minutes(); minutes > 0 {
+		rate := float64(done-t.first.done) / minutes
+		if t.step == 0 {
+			t.status.Baz2bamZmwsPerMin = rate
+		} else {
+			t.status.Ccs2bamZmwsPerMin = rate
+		}
+	}
+	t.status.NumZmws = done
+	fraction := float64(done) / float64(total)
+	if fraction > 1 {
+		fraction = 1
+	}
+	t.status.Progress = (float64(t.step) + fraction) / float64(t.steps)
+}
+
+// Moves on to the next step.
+func (t *progressTracker) nextStep() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.step++
+	t.first = progressSample{}
+	t.partial = nil
+	t.status.Progress = float64(t.step) / float64(t.steps)
+}
+
+// Records the peak RSS of pid, the child running the current step.
+func (t *progressTracker) sampleRss(pid int) {
+	gb, err := peakRssGb(pid)
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	peak := &t.status.Baz2bamPeakRssGb
+	if t.step > 0 {
+		peak = &t.status.Ccs2bamPeakRssGb
+	}
+	if gb > *peak {
+		*peak = gb
+	}
+}
+
+// The larger peak RSS of the steps so far.
+func (t *progressTracker) peakRssGb() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.status.Ccs2bamPeakRssGb > t.status.Baz2bamPeakRssGb {
+		return t.status.Ccs2bamPeakRssGb
+	}
+	return t.status.Baz2bamPeakRssGb
+}
+
+// The whole job succeeded.
+func (t *progressTracker) complete() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.Progress = 1
+}
+
+// Copies the numbers into status, leaving its OutputUrls and QueuePosition alone.
+func (t *progressTracker) update(status *PostprimaryStatusObject) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	urls, position := status.OutputUrls, status.QueuePosition
+	*status = t.status
+	status.OutputUrls, status.QueuePosition = urls, position
+}
+
+// The peak resident set size ("high water mark") of pid, in GiB.
+func peakRssGb(pid int) (float64, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "VmHWM:" && fields[2] == "kB" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return float64(kb) / (1 << 20), nil
+		}
+	}
+	return 0, fmt.Errorf("no VmHWM for pid %d", pid)
+}
@@ -0,0 +1,86 @@
+package web
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func replay(t *testing.T, tracker *progressTracker, fixture string) {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// In odd pieces, as a pipe might deliver it.
+	for len(b) > 0 {
+		n := 7
+		if n > len(b) {
+			n = len(b)
+		}
+		tracker.Write(b[:n])
+		b = b[n:]
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestProgressTracker(t *testing.T) {
+	tracker := newProgressTracker(2)
+	var status PostprimaryStatusObject
+
+	replay(t, tracker, "baz2bam_progress.log")
+	tracker.update(&status)
+	if status.NumZmws != 3000000 || !near(status.Baz2bamZmwsPerMin, 2e6) || !near(status.Progress, 0.375) {
+		t.Errorf("baz2bam: got %+v", status)
+	}
+
+	tracker.nextStep()
+	replay(t, tracker, "ccs_progress.log")
+	tracker.update(&status)
+	if status.NumZmws != 800000 || !near(status.Ccs2bamZmwsPerMin, 2e5) || !near(status.Progress, 0.6) {
+		t.Errorf("ccs: got %+v", status)
+	}
+	if !near(status.Baz2bamZmwsPerMin, 2e6) {
+		t.Errorf("lost the baz2bam rate: %+v", status)
+	}
+
+	tracker.complete()
+	tracker.update(&status)
+	if status.Progress != 1 {
+		t.Errorf("got %+v", status)
+	}
+}
+
+func TestPeakRss(t *testing.T) {
+	gb, err := peakRssGb(os.Getpid())
+	if err != nil {
+		t.Skip(err)
+	}
+	if gb <= 0 || gb > 1024 {
+		t.Errorf("got %g GiB", gb)
+	}
+}
+
+func TestPostprimaryProgress(t *testing.T) {
+	fixture, _ := filepath.Abs("testdata/baz2bam_progress.log")
+	t.Setenv("FAKE_STDOUT", fixture)
+	router, st := newTestState(t, fakeConfig(t))
+	newTestStorage(t, router, "m1")
+	serve(router, "POST", "/postprimaries", `{
+		"mid": "m1",
+		"bazFileUrl": "http://localhost:23632/storages/m1/m1.baz",
+		"outputPrefixUrl": "http://localhost:23632/storages/m1/m1"
+	}`)
+	waitPostprimary(t, st, "m1")
+
+	var obj PostprimaryObject
+	decode(t, serve(router, "GET", "/postprimaries/m1", ""), &obj)
+	status := obj.PostprimaryStatus
+	if status.Progress != 1 || status.NumZmws != 3000000 || !near(status.Baz2bamZmwsPerMin, 2e6) {
+		t.Errorf("got %+v", status)
+	}
+}
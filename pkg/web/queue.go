@@ -0,0 +1,135 @@
+package web
+
+import "sync"
+
+// How many finished jobs the RSS estimate looks back on.
+const rssHistoryLength = 10
+
+// PostprimaryQueue starts postprimary jobs in the order they were
+// queued, running at most max at once. With a memory limit, a job only
+// starts if the estimated RSS of it and the running jobs fits, the
+// estimate being the largest peak RSS of the recent jobs.
+type PostprimaryQueue struct {
+	max        int
+	rssLimitGb float64
+
+	mu      sync.Mutex
+	queued  []*postprimaryJob
+	running map[*postprimaryJob]bool
+	history []float64
+}
+
+// NewPostprimaryQueue returns an empty queue. A max below 1 means 1;
+// a rssLimitGb of 0 means no limit.
+func NewPostprimaryQueue(max int, rssLimitGb float64) *PostprimaryQueue {
+	if max < 1 {
+		max = 1
+	}
+	return &PostprimaryQueue{
+		max:        max,
+		rssLimitGb: rssLimitGb,
+		running:    make(map[*postprimaryJob]bool),
+	}
+}
+
+func (q *PostprimaryQueue) enqueue(job *postprimaryJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.queued = append(q.queued, job)
+	q.schedule()
+}
+
+// Counts a job that an earlier pa-ws started as running, until done.
+func (q *PostprimaryQueue) adopt(job *postprimaryJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.running[job] = true
+}
+
+// Takes the job for mid out of the queue, if it has not started yet.
+func (q *PostprimaryQueue) cancel(mid string) *postprimaryJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, job := range q.queued {
+		if job.mid == mid {
+			q.queued = append(q.queued[:i], q.queued[i+1:]...)
+			q.updatePositions()
+			return job
+		}
+	}
+	return nil
+}
+
+// Number of jobs waiting for a slot.
+func (q *PostprimaryQueue) queuedCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.queued)
+}
+
+// Called once a job has finished, to make room for the next.
+func (q *PostprimaryQueue) done(job *postprimaryJob, peakRssGb float64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.running[job] {
+		return
+	}
+	delete(q.running, job)
+	if peakRssGb > 0 {
+		q.history = append(q.history, peakRssGb)
+		if len(q.history) > rssHistoryLength {
+			q.history = q.history[1:]
+		}
+	}
+	q.schedule()
+}
+
+// Starts jobs from the head of the queue while they fit. The caller
+// holds the lock.
+func (q *PostprimaryQueue) schedule() {
+	for len(q.queued) > 0 && q.fits() {
+		job := q.queued[0]
+		q.queued = q.queued[1:]
+		if err := job.start(); err != nil {
+			// finish calls back into the queue, so not while locked.
+			go job.finish(failedStatus())
+			continue
+		}
+		q.running[job] = true
+		job.state.Postprimaries.updateJob(job, func(obj *PostprimaryObject) {
+			obj.PostprimaryStatus.QueuePosition = 0
+		})
+	}
+	q.updatePositions()
+}
+
+func (q *PostprimaryQueue) fits() bool {
+	if len(q.running) >= q.max {
+		return false
+	}
+	if q.rssLimitGb <= 0 || len(q.running) == 0 {
+		// Even a job estimated over the limit gets to run, alone.
+		return true
+	}
+	return float64(len(q.running)+1)*q.estimateRssGb() <= q.rssLimitGb
+}
+
+func (q *PostprimaryQueue) estimateRssGb() float64 {
+	var estimate float64
+	for _, gb := range q.history {
+		if gb > estimate {
+			estimate = gb
+		}
+	}
+	return estimate
+}
+
+// Shows each queued job its 1-based place in line. The caller holds the lock.
+func (q *PostprimaryQueue) updatePositions() {
+	for i, job := range q.queued {
+		position := int32(i + 1)
+		job.state.Postprimaries.updateJob(job, func(obj *PostprimaryObject) {
+			obj.PostprimaryStatus.QueuePosition = position
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package web
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postprimaryFileBody(dir, mid string) string {
+	return `{"mid": "` + mid + `", "bazFileUrl": "file:` + dir + `/` + mid + `.baz", "outputPrefixUrl": "file:` + dir + `/` + mid + `"}`
+}
+
+// Polls until the postprimary for mid is RUNNING.
+func waitRunning(t *testing.T, st *State, mid string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if obj, _ := st.Postprimaries.Get(mid); obj.ProcessStatus.ExecutionStatus == Running {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s never started", mid)
+}
+
+func TestPostprimaryQueue(t *testing.T) {
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	t.Setenv("FAKE_ARGS", argsFile)
+	t.Setenv("FAKE_SLEEP", "10")
+	config := fakeConfig(t)
+	config.MaxPostprimaries = 1
+	router, st := newTestState(t, config)
+
+	for _, mid := range []string{"m1", "m2", "m3"} {
+		if w := serve(router, "POST", "/postprimaries", postprimaryFileBody(dir, mid)); w.Code != http.StatusOK {
+			t.Fatalf("got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	for mid, want := range map[string]int32{"m1": 0, "m2": 1, "m3": 2} {
+		obj, _ := st.Postprimaries.Get(mid)
+		if obj.PostprimaryStatus.QueuePosition != want {
+			t.Errorf("%s: got position %d, want %d", mid, obj.PostprimaryStatus.QueuePosition, want)
+		}
+	}
+	if obj, _ := st.Postprimaries.Get("m2"); obj.ProcessStatus.ExecutionStatus != Ready {
+		t.Errorf("m2 is %s", obj.ProcessStatus.ExecutionStatus)
+	}
+
+	if w := serve(router, "DELETE", "/postprimaries/m2", ""); w.Code != http.StatusOK {
+		t.Errorf("cancel: got %d", w.Code)
+	}
+	if obj, _ := st.Postprimaries.Get("m3"); obj.PostprimaryStatus.QueuePosition != 1 {
+		t.Errorf("m3: got position %d", obj.PostprimaryStatus.QueuePosition)
+	}
+
+	serve(router, "POST", "/postprimaries/m1/stop", "")
+	waitRunning(t, st, "m3")
+	serve(router, "POST", "/postprimaries/m3/stop", "")
+	waitPostprimary(t, st, "m3")
+
+	b, _ := os.ReadFile(argsFile)
+	if strings.Contains(string(b), "m2.baz") {
+		t.Errorf("m2 ran anyway:\n%s", b)
+	}
+}
+
+func TestStopQueuedPostprimary(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("FAKE_SLEEP", "10")
+	config := fakeConfig(t)
+	config.MaxPostprimaries = 1
+	router, st := newTestState(t, config)
+	serve(router, "POST", "/postprimaries", postprimaryFileBody(dir, "m1"))
+	serve(router, "POST", "/postprimaries", postprimaryFileBody(dir, "m2"))
+
+	serve(router, "POST", "/postprimaries/m2/stop", "")
+	if obj, _ := st.Postprimaries.Get("m2"); obj.ProcessStatus.ExecutionStatus != Complete ||
+		obj.ProcessStatus.CompletionStatus != CompletionAborted {
+		t.Errorf("got %+v", obj.ProcessStatus)
+	}
+	serve(router, "POST", "/postprimaries/m1/stop", "")
+	waitPostprimary(t, st, "m1")
+}
+
+func TestQueueFitsRss(t *testing.T) {
+	q := NewPostprimaryQueue(4, 10)
+	if !q.fits() {
+		t.Error("empty queue is full")
+	}
+	q.running[&postprimaryJob{}] = true
+	if !q.fits() {
+		t.Error("no history, but no room")
+	}
+	q.history = []float64{3, 5.6}
+	if q.fits() {
+		t.Error("2 x 5.6 GiB fit in 10 GiB")
+	}
+	q.rssLimitGb = 12
+	if !q.fits() {
+		t.Error("2 x 5.6 GiB did not fit in 12 GiB")
+	}
+}
@@ -1,171 +1,850 @@
 package web
 
 import (
+	"bytes"
+	"fmt"
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
+	"image/png"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func AddRoutes(router *gin.Engine) {
-	router.GET("/status", getStatus)
-	router.GET("/sockets", getSockets)
-	router.GET("/sockets/:id", getSocketById)
-	router.POST("/sockets/reset", resetSockets)
-	router.POST("/sockets/:id/reset", resetSocketById)
-	router.GET("/sockets/:id/image", getImageBySocketId)
-	router.GET("/sockets/:id/basecaller", getBasecallerBySocketId)
-	router.POST("/sockets/:id/basecaller/start", startBasecallerBySocketId)
-	router.POST("/sockets/:id/basecaller/stop", stopBasecallerBySocketId)
-	router.POST("/sockets/:id/basecaller/reset", resetBasecallerBySocketId)
-	router.GET("/sockets/:id/darkcal", getDarkcalBySocketId)
-	router.POST("/sockets/:id/darkcal/start", startDarkcalBySocketId)
-	router.POST("/sockets/:id/darkcal/stop", stopDarkcalBySocketId)
-	router.POST("/sockets/:id/darkcal/reset", resetDarkcalBySocketId)
-	router.GET("/sockets/:id/loadingcal", getLoadingcalBySocketId)
-	router.POST("/sockets/:id/loadingcal/start", startLoadingcalBySocketId)
-	router.POST("/sockets/:id/loadingcal/stop", stopLoadingcalBySocketId)
-	router.POST("/sockets/:id/loadingcal/reset", resetLoadingcalBySocketId)
-	router.GET("/storages", listStorageMids)
-	router.POST("/storages", createStorage)
-	router.GET("/storages/:mid", getStorageByMid)
-	router.DELETE("/storages/:mid", deleteStorageByMid)
-	router.POST("/storages/:mid/free", freeStorageByMid)
-	router.GET("/postprimaries", listPostprimaryMids)
-	router.POST("/postprimaries", startPostprimary)
-	router.DELETE("/postprimaries", deletePostprimaries)
-	router.GET("/postprimaries/:mid", getPostprimaryByMid)
-	router.DELETE("/postprimaries/:mid", deletePostprimaryByMid)
-	router.POST("/postprimaries/:mid/stop", stopPostprimaryByMid)
+// AddRoutes builds the state of a freshly started pa-ws, and routes the
+// API to it. Close the state once the router is done with it.
+func AddRoutes(router *gin.Engine, config Config) (*State, error) {
+	st, err := NewState(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := st.restore(); err != nil {
+		st.Close()
+		return nil, err
+	}
+
+	router.GET("/status", st.getStatus)
+	router.GET("/config", st.getConfig)
+	router.GET("/openapi.json", st.getOpenapi)
+	router.GET("/docs", st.getDocs)
+	router.GET("/events", st.getEvents)
+	router.GET("/webhooks", st.listWebhookDeliveries)
+	router.GET("/sockets", st.getSockets)
+	router.GET("/sockets/:id", st.getSocketById)
+	router.POST("/sockets/reset", st.resetSockets)
+	router.POST("/sockets/:id/reset", st.resetSocketById)
+	router.GET("/sockets/:id/image", st.getImageBySocketId)
+	router.GET("/sockets/:id/basecaller", st.getBasecallerBySocketId)
+	router.POST("/sockets/:id/basecaller/start", st.startBasecallerBySocketId)
+	router.POST("/sockets/:id/basecaller/stop", st.stopBasecallerBySocketId)
+	router.POST("/sockets/:id/basecaller/reset", st.resetBasecallerBySocketId)
+	router.GET("/sockets/:id/basecaller/rtmetrics", st.getRtMetricsBySocketId)
+	router.GET("/sockets/:id/basecaller/log", st.getBasecallerLogBySocketId)
+	router.GET("/sockets/:id/darkcal", st.getDarkcalBySocketId)
+	router.POST("/sockets/:id/darkcal/start", st.startDarkcalBySocketId)
+	router.POST("/sockets/:id/darkcal/stop", st.stopDarkcalBySocketId)
+	router.POST("/sockets/:id/darkcal/reset", st.resetDarkcalBySocketId)
+	router.GET("/sockets/:id/darkcal/log", st.getDarkcalLogBySocketId)
+	router.GET("/sockets/:id/loadingcal", st.getLoadingcalBySocketId)
+	router.POST("/sockets/:id/loadingcal/start", st.startLoadingcalBySocketId)
+	router.POST("/sockets/:id/loadingcal/stop", st.stopLoadingcalBySocketId)
+	router.POST("/sockets/:id/loadingcal/reset", st.resetLoadingcalBySocketId)
+	router.GET("/sockets/:id/loadingcal/log", st.getLoadingcalLogBySocketId)
+	router.GET("/storages", st.listStorageMids)
+	router.POST("/storages", st.createStorage)
+	router.GET("/storages/:mid", st.getStorageByMid)
+	router.DELETE("/storages/:mid", st.deleteStorageByMid)
+	router.POST("/storages/:mid/free", st.freeStorageByMid)
+	router.GET("/storages/:mid/*path", st.getStorageFile)
+	router.HEAD("/storages/:mid/*path", st.getStorageFile)
+	router.GET("/postprimaries", st.listPostprimaryMids)
+	router.POST("/postprimaries", st.startPostprimary)
+	router.DELETE("/postprimaries", st.deletePostprimaries)
+	router.GET("/postprimaries/:mid", st.getPostprimaryByMid)
+	router.DELETE("/postprimaries/:mid", st.deletePostprimaryByMid)
+	router.POST("/postprimaries/:mid/stop", st.stopPostprimaryByMid)
+	router.GET("/postprimaries/:mid/log", st.getPostprimaryLogByMid)
+	return st, nil
 }
 
 // Returns top level status of the pa-ws process.
-func getStatus(c *gin.Context) {
-	var status PawsStatusObject
-	c.IndentedJSON(http.StatusOK, status)
+func (st *State) getStatus(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, st.pawsStatus(time.Now()))
+}
+
+// Returns the effective configuration, with secrets redacted.
+func (st *State) getConfig(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, st.Config.Redacted())
+}
+
+// Streams changes as server-sent events, each with its type and id. The events are the process status of the socket apps and postprimaries, postprimary progress, and storages created, deleted and freed. A client that reconnects with the Last-Event-ID header gets the events it missed while they are still buffered, and a "missed" event if not. The query parameters socketId and mid select the events of one socket or one movie.
+func (st *State) getEvents(c *gin.Context) {
+	filter := eventFilter{socketId: c.Query("socketId"), mid: c.Query("mid")}
+	lastId := c.GetHeader("Last-Event-ID")
+	after, err := strconv.ParseInt(lastId, 10, 64)
+	if lastId != "" && err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "invalid Last-Event-ID " + strconv.Quote(lastId)})
+		return
+	}
+	missed, ch, complete := st.Events.subscribe(after, lastId != "")
+	defer st.Events.unsubscribe(ch)
+
+	c.Header("Content-Type", sse.ContentType)
+	c.Header("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
+	c.Writer.WriteHeaderNow()
+	if !complete {
+		c.Render(-1, sse.Event{Event: EventMissed, Data: "events since " + lastId + " are no longer buffered"})
+	}
+	send := func(e EventObject) {
+		if filter.matches(e) {
+			c.Render(-1, sse.Event{Event: e.Type, Id: strconv.FormatInt(e.Id, 10), Data: e})
+		}
+	}
+	for _, e := range missed {
+		send(e)
+	}
+	c.Writer.Flush()
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			send(e)
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// Returns the latest deliveries of callbacks, oldest first. The query parameter mid selects those of one movie.
+func (st *State) listWebhookDeliveries(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, st.Webhooks.Deliveries(c.Query("mid")))
 }
 
 // Returns a list of socket ids.
-func getSockets(c *gin.Context) {
-	var socketIds []string = []string{"1", "2", "3", "4"}
-	c.IndentedJSON(http.StatusOK, socketIds)
+func (st *State) getSockets(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, st.Sockets.Ids())
 }
 
 // Returns the socket object indexed by the sock_id.
-func getSocketById(c *gin.Context) {
-	//panic("I AM LOST!")
-	//id := c.Param("id")
-
-	//var sockets []SocketObject = []SocketObject{}
-	//socket := sockets[id]
-	var obj SocketObject
+func (st *State) getSocketById(c *gin.Context) {
+	obj, ok := st.Sockets.Get(c.Param("id"))
+	if !ok {
+		socketNotFound(c)
+		return
+	}
 	c.IndentedJSON(http.StatusOK, obj)
 }
 
 // Resets all "one shot" app resources for each of the sockets.
-func resetSockets(c *gin.Context) {
+// Either every socket is reset, or none are and the response lists the conflicts.
+func (st *State) resetSockets(c *gin.Context) {
+	var conflicts []*TransitionError
+	st.Sockets.UpdateAll(func(sockets []*SocketObject) error {
+		for _, s := range sockets {
+			conflicts = append(conflicts, resetConflicts(s)...)
+		}
+		if len(conflicts) != 0 {
+			return nil
+		}
+		for _, s := range sockets {
+			for _, app := range socketApps {
+				app.reset(s)
+			}
+		}
+		return nil
+	})
+	if len(conflicts) != 0 {
+		c.IndentedJSON(http.StatusConflict, ResetConflictObject{
+			Message:   "some sockets cannot be reset, so none were",
+			Conflicts: conflicts,
+		})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, st.Sockets.Ids())
 }
 
 // Resets all "one shot" app resources for the socket.
-func resetSocketById(c *gin.Context) {
+func (st *State) resetSocketById(c *gin.Context) {
+	id := c.Param("id")
+	var conflicts []*TransitionError
+	err := st.Sockets.Update(id, func(s *SocketObject) error {
+		conflicts = resetConflicts(s)
+		if len(conflicts) != 0 {
+			return nil
+		}
+		for _, app := range socketApps {
+			app.reset(s)
+		}
+		return nil
+	})
+	if err != nil {
+		socketNotFound(c)
+		return
+	}
+	if len(conflicts) != 0 {
+		c.IndentedJSON(http.StatusConflict, ResetConflictObject{
+			Message:   "socket " + id + " cannot be reset",
+			Conflicts: conflicts,
+		})
+		return
+	}
+	socket, _ := st.Sockets.Get(id)
+	c.IndentedJSON(http.StatusOK, socket)
 }
 
 // Returns a single image from the socket.
-func getImageBySocketId(c *gin.Context) {
+// Query parameters:
+//
+//	format=png        8-bit grayscale, scaled from the darkest pixel to the brightest (the default)
+//	format=raw        the 16-bit pixels, little-endian, row by row; the
+//	                  X-Frame-Width, X-Frame-Height and X-Frame-Dtype headers say how many
+//	roi=0,0,64,128    only the part [row, col, rows, cols] of the frame
+//	downsample=4      the average of each block of 4 by 4 pixels
+func (st *State) getImageBySocketId(c *gin.Context) {
+	id := c.Param("id")
+	if _, ok := st.Sockets.Get(id); !ok {
+		socketNotFound(c)
+		return
+	}
+	q, err := parseImageQuery(c.DefaultQuery("format", "png"), c.Query("roi"), c.DefaultQuery("downsample", "1"))
+	if err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if st.Frames == nil {
+		c.IndentedJSON(http.StatusServiceUnavailable, gin.H{"message": ErrNoFrameSource.Error()})
+		return
+	}
+	frame, err := st.Frames.Frame(id)
+	if err != nil {
+		c.IndentedJSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	roi, err := q.fit(frame)
+	if err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	frame = frame.crop(roi, q.downsample)
+	if q.format == "raw" {
+		c.Header("X-Frame-Width", strconv.Itoa(frame.Width))
+		c.Header("X-Frame-Height", strconv.Itoa(frame.Height))
+		c.Header("X-Frame-Dtype", "uint16le")
+		c.Data(http.StatusOK, "application/octet-stream", frame.raw())
+		return
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, frame.image()); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "image/png", buf.Bytes())
 }
 
 // Returns the basecaller object indexed by the socket {id}.
-func getBasecallerBySocketId(c *gin.Context) {
-	var obj SocketBasecallerObject
-	c.IndentedJSON(http.StatusOK, obj)
+func (st *State) getBasecallerBySocketId(c *gin.Context) {
+	obj, ok := st.Sockets.Get(c.Param("id"))
+	if !ok {
+		socketNotFound(c)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, obj.Basecaller)
 }
 
 // Start the basecaller process on socket {id}.
-func startBasecallerBySocketId(c *gin.Context) {
+func (st *State) startBasecallerBySocketId(c *gin.Context) {
+	var obj SocketBasecallerObject
+	if !st.bindJSON(c, &obj) {
+		return
+	}
+	if _, ok := st.Sockets.Get(c.Param("id")); !ok {
+		socketNotFound(c)
+		return
+	}
+	if err := obj.validate(); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if obj.DarkCalFileUrl != "" {
+		if err := st.checkDarkcalFile(obj.DarkCalFileUrl); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+	}
+	args, err := st.basecallerArgs(obj)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	store := func(s *SocketObject) { s.Basecaller = obj }
+	st.startSocketApp(c, basecallerApp, store, st.Config.SmrtBasecaller, args)
 }
 
 // Gracefully aborts the basecalling process on socket {id}. This must be called before a POST to "reset". Note The the process will not stop immediately. The client must poll the endpoint until the "process_status.execution_status" is "COMPLETE".
-func stopBasecallerBySocketId(c *gin.Context) {
+func (st *State) stopBasecallerBySocketId(c *gin.Context) {
+	st.stopSocketApp(c, basecallerApp)
 }
 
 // Resets the basecaller resource on socket {id}.
-func resetBasecallerBySocketId(c *gin.Context) {
+func (st *State) resetBasecallerBySocketId(c *gin.Context) {
+	st.resetSocketApp(c, basecallerApp)
+}
+
+// Returns the last lines of the log of the basecaller on socket {id}, 100 unless the query parameter lines says otherwise.
+func (st *State) getBasecallerLogBySocketId(c *gin.Context) {
+	st.tailSocketAppLog(c, basecallerApp)
+}
+
+// Returns the latest RT Metrics of the basecaller on socket {id}, oldest first. The history starts over with each basecaller.
+func (st *State) getRtMetricsBySocketId(c *gin.Context) {
+	id := c.Param("id")
+	if _, ok := st.Sockets.Get(id); !ok {
+		socketNotFound(c)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, st.RtMetrics.Get(id))
 }
 
 // Returns the darkcal object indexed by socket {id}.
-func getDarkcalBySocketId(c *gin.Context) {
-	var obj SocketDarkcalObject
-	c.IndentedJSON(http.StatusOK, obj)
+func (st *State) getDarkcalBySocketId(c *gin.Context) {
+	obj, ok := st.Sockets.Get(c.Param("id"))
+	if !ok {
+		socketNotFound(c)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, obj.Darkcal)
 }
 
 // Starts a darkcal process on socket {id}.
-func startDarkcalBySocketId(c *gin.Context) {
+func (st *State) startDarkcalBySocketId(c *gin.Context) {
+	var obj SocketDarkcalObject
+	if !st.bindJSON(c, &obj) {
+		return
+	}
+	if _, ok := st.Sockets.Get(c.Param("id")); !ok {
+		socketNotFound(c)
+		return
+	}
+	if err := obj.validate(); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	args, err := st.darkcalArgs(obj)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	store := func(s *SocketObject) { s.Darkcal = obj }
+	st.startSocketApp(c, darkcalApp, store, st.Config.PaCal, args)
 }
 
 // Gracefully aborts the darkcal process on socket {id}.
-func stopDarkcalBySocketId(c *gin.Context) {
+func (st *State) stopDarkcalBySocketId(c *gin.Context) {
+	st.stopSocketApp(c, darkcalApp)
 }
 
 // Resets the darkcal resource on socket {id}.
-func resetDarkcalBySocketId(c *gin.Context) {
+func (st *State) resetDarkcalBySocketId(c *gin.Context) {
+	st.resetSocketApp(c, darkcalApp)
+}
+
+// Returns the last lines of the log of the darkcal on socket {id}, 100 unless the query parameter lines says otherwise.
+func (st *State) getDarkcalLogBySocketId(c *gin.Context) {
+	st.tailSocketAppLog(c, darkcalApp)
 }
 
 // Returns the loadingcal object indexed by socket {id}.
-func getLoadingcalBySocketId(c *gin.Context) {
-	var obj SocketLoadingcalObject
-	c.IndentedJSON(http.StatusOK, obj)
+func (st *State) getLoadingcalBySocketId(c *gin.Context) {
+	obj, ok := st.Sockets.Get(c.Param("id"))
+	if !ok {
+		socketNotFound(c)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, obj.Loadingcal)
 }
 
 // Starts a loadingcal process on socket {id}.
-func startLoadingcalBySocketId(c *gin.Context) {
+func (st *State) startLoadingcalBySocketId(c *gin.Context) {
+	var obj SocketLoadingcalObject
+	if !st.bindJSON(c, &obj) {
+		return
+	}
+	if _, ok := st.Sockets.Get(c.Param("id")); !ok {
+		socketNotFound(c)
+		return
+	}
+	if err := obj.validate(); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if err := st.checkDarkcalFile(obj.DarkFrameFileUrl); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	args, err := st.loadingcalArgs(obj)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	store := func(s *SocketObject) { s.Loadingcal = obj }
+	st.startSocketApp(c, loadingcalApp, store, st.Config.PaCal, args)
 }
 
 // Gracefully aborts the loadingcal process on socket {id}.
-func stopLoadingcalBySocketId(c *gin.Context) {
+func (st *State) stopLoadingcalBySocketId(c *gin.Context) {
+	st.stopSocketApp(c, loadingcalApp)
 }
 
 // Resets the loadingcal resource on socket {id}.
-func resetLoadingcalBySocketId(c *gin.Context) {
+func (st *State) resetLoadingcalBySocketId(c *gin.Context) {
+	st.resetSocketApp(c, loadingcalApp)
+}
+
+// Returns the last lines of the log of the loadingcal on socket {id}, 100 unless the query parameter lines says otherwise.
+func (st *State) getLoadingcalLogBySocketId(c *gin.Context) {
+	st.tailSocketAppLog(c, loadingcalApp)
+}
+
+// Decodes the request body into obj, or responds 400. In strict mode,
+// fields that obj does not have are refused too.
+func (st *State) bindJSON(c *gin.Context, obj interface{}) bool {
+	if err := decodeJSON(c.Request.Body, obj, st.Config.StrictRequests); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return false
+	}
+	return true
+}
+
+// Responds 400 with every problem found in the request body.
+func invalidRequest(c *gin.Context, err error) {
+	body := ErrorObject{Message: err.Error()}
+	if ve, ok := err.(*ValidationError); ok {
+		body.Errors = ve.Errors
+	}
+	c.IndentedJSON(http.StatusBadRequest, body)
+}
+
+func socketNotFound(c *gin.Context) {
+	c.IndentedJSON(http.StatusNotFound, gin.H{"message": "socket not found"})
+}
+
+// Records the requested object with store, moves the app on socket
+// {id} to RUNNING, and launches the child.
+func (st *State) startSocketApp(c *gin.Context, app socketApp, store func(*SocketObject), path string, args []string) {
+	id := c.Param("id")
+	requested := SocketObject{SocketId: id}
+	store(&requested)
+	if err := st.checkLogUrl(app.common(&requested).LogUrl); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	// Claimed first, for a stop that comes in once the app is RUNNING
+	// to be held until its process is there to stop.
+	st.Processes.Claim(app.key(id))
+	var prior SocketObject
+	err := st.Sockets.Update(id, func(s *SocketObject) error {
+		from := app.status(s).ExecutionStatus
+		if err := checkTransition(app.resource(id), from, Running); err != nil {
+			return err
+		}
+		prior = *s
+		store(s)
+		*app.status(s) = runningStatus()
+		return nil
+	})
+	if err != nil {
+		st.Processes.Unclaim(app.key(id))
+		updateFailed(c, err)
+		return
+	}
+	if err := st.checkStorages(app.urls(&requested)); err != nil {
+		st.Processes.Unclaim(app.key(id))
+		st.launchFailed(c, id, app, &prior, err)
+		return
+	}
+	if err := st.launch(app, id, path, args); err != nil {
+		st.launchFailed(c, id, app, &prior, err)
+		return
+	}
+	socket, _ := st.Sockets.Get(id)
+	c.IndentedJSON(http.StatusOK, app.object(&socket))
+}
+
+// Responds to a failed SocketRegistry.Update.
+func updateFailed(c *gin.Context, err error) {
+	if err == ErrSocketNotFound {
+		socketNotFound(c)
+		return
+	}
+	if terr, ok := err.(*TransitionError); ok {
+		c.IndentedJSON(http.StatusConflict, terr)
+		return
+	}
+	c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+}
+
+func (st *State) resetSocketApp(c *gin.Context, app socketApp) {
+	id := c.Param("id")
+	if err := st.Sockets.Update(id, app.reset); err != nil {
+		updateFailed(c, err)
+		return
+	}
+	socket, _ := st.Sockets.Get(id)
+	c.IndentedJSON(http.StatusOK, app.object(&socket))
+}
+
+// Stopping is asynchronous and idempotent; whatever the state, the
+// response is the app object as it is now.
+func (st *State) stopSocketApp(c *gin.Context, app socketApp) {
+	id := c.Param("id")
+	if _, ok := st.Sockets.Get(id); !ok {
+		socketNotFound(c)
+		return
+	}
+	st.stopProcess(app.key(id))
+	socket, _ := st.Sockets.Get(id)
+	c.IndentedJSON(http.StatusOK, app.object(&socket))
+}
+
+func (st *State) tailSocketAppLog(c *gin.Context, app socketApp) {
+	socket, ok := st.Sockets.Get(c.Param("id"))
+	if !ok {
+		socketNotFound(c)
+		return
+	}
+	path, err := st.socketLogPath(app, &socket)
+	respondTail(c, path, app.common(&socket).LogLevel, err)
+}
+
+// Responds with the tail of the log at path, as plain text.
+func respondTail(c *gin.Context, path string, level LogLevelEnum, err error) {
+	n, nerr := strconv.Atoi(c.DefaultQuery("lines", "100"))
+	if nerr != nil || n <= 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "lines must be a positive integer, not " + strconv.Quote(c.Query("lines"))})
+		return
+	}
+	if _, ok := err.(*UnsafeLogError); ok {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		return
+	} else if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	if path == "" {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "no log is kept"})
+		return
+	}
+	lines, err := tailLog(path, n, level)
+	if os.IsNotExist(err) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "no log yet"})
+		return
+	} else if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	var text strings.Builder
+	for _, line := range lines {
+		text.WriteString(line)
+		text.WriteByte('\n')
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(text.String()))
+}
+
+// The child never started, so the app goes straight to COMPLETE. If
+// that is because the last one is still running, the start is refused,
+// and the app is put back as it was before.
+func (st *State) launchFailed(c *gin.Context, id string, app socketApp, prior *SocketObject, err error) {
+	if err == ErrProcessRunning {
+		st.Sockets.Update(id, func(s *SocketObject) error {
+			app.restore(s, prior)
+			return nil
+		})
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	}
+	status := failedStatus()
+	st.Sockets.Update(id, func(s *SocketObject) error {
+		*app.status(s) = status
+		return nil
+	})
+	c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "cannot start " + app.name + ": " + err.Error()})
+}
+
+func storageNotFound(c *gin.Context) {
+	c.IndentedJSON(http.StatusNotFound, gin.H{"message": ErrStorageNotFound.Error()})
+}
+
+// Responds to a failed StorageManager call.
+func storageFailed(c *gin.Context, err error) {
+	switch err.(type) {
+	case *UnsafePathError:
+		c.IndentedJSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		return
+	case *StorageInUseError:
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	}
+	switch err {
+	case ErrStorageNotFound:
+		storageNotFound(c)
+	case ErrStorageExists, ErrStorageNotFreed, ErrStorageBusy:
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": err.Error()})
+	default:
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+	}
+}
+
+// Returns a *StorageInUseError if a running app, or a running or
+// queued postprimary, may be using the storage of mid.
+func (st *State) storageInUse(mid string) error {
+	for _, id := range st.Sockets.Ids() {
+		s, _ := st.Sockets.Get(id)
+		for _, app := range socketApps {
+			if app.status(&s).ExecutionStatus == Running && app.uses(&s, mid) {
+				return &StorageInUseError{mid, fmt.Sprintf("%s on socket %s is running", app.name, id)}
+			}
+		}
+	}
+	for _, ppmid := range st.Postprimaries.Mids() {
+		obj, _ := st.Postprimaries.Get(ppmid)
+		switch obj.ProcessStatus.ExecutionStatus {
+		case Ready, Running:
+			if obj.uses(mid) {
+				return &StorageInUseError{mid, fmt.Sprintf("postprimary %s is %s", ppmid, obj.ProcessStatus.ExecutionStatus)}
+			}
+		}
+	}
+	return nil
+}
+
+// Checks that the storages of urls are still there to use. A start
+// resolves its URLs before its app is RUNNING, and so before a free
+// would see it in use; checking again once it is RUNNING closes the
+// gap, since a free marks the storage before it checks.
+func (st *State) checkStorages(urls []string) error {
+	for _, url := range urls {
+		if url == "" {
+			continue
+		}
+		if _, err := st.Resolver.Path(url); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Returns a list of MIDs for each storage object.
-func listStorageMids(c *gin.Context) {
+func (st *State) listStorageMids(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, st.Storages.Mids())
 }
 
 // Creates a storages resource for a movie.
-func createStorage(c *gin.Context) {
+func (st *State) createStorage(c *gin.Context) {
+	var req StorageObject
+	if !st.bindJSON(c, &req) {
+		return
+	}
+	if !st.Storages.validMid(req.Mid) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "invalid mid " + strconv.Quote(req.Mid)})
+		return
+	}
+	obj, err := st.Storages.Create(req)
+	if err != nil {
+		storageFailed(c, err)
+		return
+	}
+	st.publishStorage(EventStorageCreated, obj)
+	c.IndentedJSON(http.StatusCreated, obj)
 }
 
 // Returns the storage object by MID.
-func getStorageByMid(c *gin.Context) {
+func (st *State) getStorageByMid(c *gin.Context) {
+	obj, err := st.Storages.Report(c.Param("mid"))
+	if err != nil {
+		storageFailed(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, obj)
 }
 
 // Deletes the storages resource for the provided movie context name (MID).
-func deleteStorageByMid(c *gin.Context) {
+func (st *State) deleteStorageByMid(c *gin.Context) {
+	obj, err := st.Storages.Delete(c.Param("mid"))
+	if err != nil {
+		storageFailed(c, err)
+		return
+	}
+	st.publishStorage(EventStorageDeleted, obj)
+	c.IndentedJSON(http.StatusOK, obj)
 }
 
 // Frees all directories and files associated with the storages resources and reclaims disk space.
-func freeStorageByMid(c *gin.Context) {
+func (st *State) freeStorageByMid(c *gin.Context) {
+	mid := c.Param("mid")
+	report, err := st.Storages.Free(mid)
+	if err != nil {
+		storageFailed(c, err)
+		return
+	}
+	if obj, ok := st.Storages.Get(mid); ok {
+		st.publishStorage(EventStorageFreed, obj)
+	}
+	c.IndentedJSON(http.StatusOK, report)
 }
 
 // Returns a list of MIDs for each postprimary object.
-func listPostprimaryMids(c *gin.Context) {
+func (st *State) listPostprimaryMids(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, st.Postprimaries.Mids())
 }
 
 // Starts a postprimary process on the provided urls to basecalling artifacts files.
-func startPostprimary(c *gin.Context) {
+// The process may wait in a queue first, READY with a queuePosition.
+func (st *State) startPostprimary(c *gin.Context) {
+	var obj PostprimaryObject
+	if !st.bindJSON(c, &obj) {
+		return
+	}
+	if !validMid.MatchString(obj.Mid) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "invalid mid " + strconv.Quote(obj.Mid)})
+		return
+	}
+	if err := obj.validate(); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if err := st.checkLogUrl(obj.LogUrl); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	job, err := st.newPostprimaryJob(obj)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	obj.PostprimaryStatus = PostprimaryStatusObject{OutputUrls: []string{}}
+	obj.ProcessStatus = readyStatus()
+	if err := st.Postprimaries.add(obj, job); err != nil {
+		job.cleanup()
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	}
+	if err := st.checkStorages(obj.urls()); err != nil {
+		st.Postprimaries.Delete(obj.Mid, true)
+		job.cleanup()
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	}
+	st.Queue.enqueue(job)
+	obj, _ = st.Postprimaries.Get(obj.Mid)
+	c.IndentedJSON(http.StatusOK, obj)
 }
 
 // Deletes all existing postprimaries resources.
-func deletePostprimaries(c *gin.Context) {
+// Query parameters narrow that down:
+//
+//	status=COMPLETE   only those with this executionStatus
+//	olderThan=24h     only those whose status has not changed for this long
+//	midPrefix=m1234   only those whose MID starts with this
+//	force=true        also those that are RUNNING, which are stopped first
+//
+// The response lists the result for each postprimary that matched.
+func (st *State) deletePostprimaries(c *gin.Context) {
+	var filter postprimaryFilter
+	switch status := ExecutionStatusEnum(c.Query("status")); status {
+	case "", Unknown, Ready, Running, Complete:
+		filter.status = status
+	default:
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "invalid status " + strconv.Quote(string(status))})
+		return
+	}
+	if v := c.Query("olderThan"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "invalid olderThan: " + err.Error()})
+			return
+		}
+		filter.olderThan = d
+	}
+	filter.midPrefix = c.Query("midPrefix")
+	force := false
+	if v := c.Query("force"); v != "" {
+		var err error
+		if force, err = strconv.ParseBool(v); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "invalid force: " + err.Error()})
+			return
+		}
+	}
+
+	now := time.Now()
+	results := []DeleteResultObject{}
+	for _, mid := range st.Postprimaries.Mids() {
+		obj, ok := st.Postprimaries.Get(mid)
+		if !ok || !filter.matches(obj, now) {
+			continue
+		}
+		result := DeleteResultObject{Mid: mid, Deleted: true}
+		if _, err := st.deletePostprimary(mid, force); err != nil {
+			result.Deleted = false
+			result.Reason = err.Error()
+		}
+		results = append(results, result)
+	}
+	c.IndentedJSON(http.StatusOK, results)
 }
 
 // Returns the postprimary object by MID.
-func getPostprimaryByMid(c *gin.Context) {
+func (st *State) getPostprimaryByMid(c *gin.Context) {
+	obj, ok := st.Postprimaries.Get(c.Param("mid"))
+	if !ok {
+		postprimaryNotFound(c)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, obj)
 }
 
 // Deletes the postprimary resource.
-func deletePostprimaryByMid(c *gin.Context) {
+func (st *State) deletePostprimaryByMid(c *gin.Context) {
+	obj, err := st.deletePostprimary(c.Param("mid"), false)
+	if err == ErrPostprimaryNotFound {
+		postprimaryNotFound(c)
+		return
+	} else if err != nil {
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, obj)
 }
 
 // Gracefully aborts the postprimary proces associated with MID.
-func stopPostprimaryByMid(c *gin.Context) {
+func (st *State) stopPostprimaryByMid(c *gin.Context) {
+	mid := c.Param("mid")
+	job := st.Postprimaries.job(mid)
+	if job == nil {
+		postprimaryNotFound(c)
+		return
+	}
+	job.stop()
+	obj, _ := st.Postprimaries.Get(mid)
+	c.IndentedJSON(http.StatusOK, obj)
+}
+
+// Returns the last lines of the log of the postprimary, 100 unless the query parameter lines says otherwise.
+func (st *State) getPostprimaryLogByMid(c *gin.Context) {
+	obj, ok := st.Postprimaries.Get(c.Param("mid"))
+	if !ok {
+		postprimaryNotFound(c)
+		return
+	}
+	path, err := st.postprimaryLogPath(obj)
+	respondTail(c, path, obj.LogLevel, err)
+}
+
+func postprimaryNotFound(c *gin.Context) {
+	c.IndentedJSON(http.StatusNotFound, gin.H{"message": ErrPostprimaryNotFound.Error()})
 }
@@ -0,0 +1,125 @@
+package web
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRouter(t *testing.T, config Config) *gin.Engine {
+	t.Helper()
+	router, _ := newTestState(t, config)
+	return router
+}
+
+// A router and its state, which is closed once the test is done, before
+// its temporary directories are removed.
+func newTestState(t *testing.T, config Config) (*gin.Engine, *State) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	st, err := AddRoutes(router, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(st.Close)
+	return router, st
+}
+
+// DefaultConfig, but with storage in a temporary directory and no state kept.
+func testConfig(t *testing.T) Config {
+	t.Helper()
+	config := DefaultConfig()
+	config.StorageRoots = []string{t.TempDir()}
+	config.StateDir = ""
+	config.LogDir = t.TempDir()
+	return config
+}
+
+func serve(router *gin.Engine, method, url, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, obj interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), obj); err != nil {
+		t.Fatalf("%v: %s", err, w.Body.String())
+	}
+}
+
+func TestGetSocketsFromConfig(t *testing.T) {
+	router := newTestRouter(t, Config{SocketIds: []string{"7", "8"}, StorageRoots: []string{t.TempDir()}})
+	w := serve(router, "GET", "/sockets", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d", w.Code)
+	}
+	var ids []string
+	decode(t, w, &ids)
+	if !reflect.DeepEqual(ids, []string{"7", "8"}) {
+		t.Errorf("got %v", ids)
+	}
+}
+
+func TestUnknownSocketIsNotFound(t *testing.T) {
+	router := newTestRouter(t, testConfig(t))
+	for _, url := range []string{"/sockets/9", "/sockets/9/basecaller", "/sockets/9/darkcal", "/sockets/9/loadingcal"} {
+		if w := serve(router, "GET", url, ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s: got %d", url, w.Code)
+		}
+	}
+}
+
+func TestSocketStartsReady(t *testing.T) {
+	router := newTestRouter(t, testConfig(t))
+	w := serve(router, "GET", "/sockets/2", "")
+	var obj SocketObject
+	decode(t, w, &obj)
+	if obj.SocketId != "2" {
+		t.Errorf("got socketId %q", obj.SocketId)
+	}
+	if obj.Basecaller.ProcessStatus.ExecutionStatus != Ready {
+		t.Errorf("got %q", obj.Basecaller.ProcessStatus.ExecutionStatus)
+	}
+}
+
+func TestRoutersKeepTheirOwnState(t *testing.T) {
+	first := newTestRouter(t, testConfig(t))
+	second := newTestRouter(t, testConfig(t))
+	serve(first, "POST", "/storages", `{"mid": "m1"}`)
+	if w := serve(second, "GET", "/storages/m1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("second router sees storage of first: %d", w.Code)
+	}
+}
+
+func TestCloseStopsGoroutines(t *testing.T) {
+	config := testConfig(t)
+	config.StateDir = t.TempDir()
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		st, err := AddRoutes(gin.New(), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st.Close()
+		st.Close()
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines left of %d", n, before)
+	}
+}
@@ -0,0 +1,199 @@
+package web
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// smrt_basecaller writes its RT Metrics next to the BAZ file, a new
+// file every so often, named for when it was written.
+var rtMetricsPattern = regexp.MustCompile(`^rtmetrics_(\d{8}_\d{6})\.xml$`)
+
+// An RT Metrics file, as smrt_basecaller writes it:
+//
+//	<RTMetrics>
+//	  <StartFrame>1024000</StartFrame>
+//	  <NumFrames>4096</NumFrames>
+//	  <Analog BaseLabel="A">
+//	    <Baseline>12.5</Baseline>
+//	    <BaselineSigma>4.2</BaselineSigma>
+//	    <Snr>11.3</Snr>
+//	    <PulseRate>1.8</PulseRate>
+//	    <PulseWidth>9.6</PulseWidth>
+//	  </Analog>
+//	  ...
+//	</RTMetrics>
+type rtMetricsXml struct {
+	XMLName    xml.Name `xml:"RTMetrics"`
+	StartFrame int64    `xml:"StartFrame"`
+	NumFrames  int64    `xml:"NumFrames"`
+	Analogs    []struct {
+		BaseLabel     BaseLabelEnum `xml:"BaseLabel,attr"`
+		Baseline      float64       `xml:"Baseline"`
+		BaselineSigma float64       `xml:"BaselineSigma"`
+		Snr           float64       `xml:"Snr"`
+		PulseRate     float64       `xml:"PulseRate"`
+		PulseWidth    float64       `xml:"PulseWidth"`
+	} `xml:"Analog"`
+}
+
+// Parses the RT Metrics file at path, which url refers to.
+func readRtMetrics(path, url string) (RtMetricsObject, error) {
+	obj := RtMetricsObject{Url: url, Analogs: []RtMetricsAnalogObject{}}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return obj, err
+	}
+	var x rtMetricsXml
+	if err := xml.Unmarshal(b, &x); err != nil {
+		return obj, err
+	}
+	if m := rtMetricsPattern.FindStringSubmatch(filepath.Base(path)); m != nil {
+		if t, err := time.Parse("20060102_150405", m[1]); err == nil {
+			obj.Timestamp = timestamp(t)
+		}
+	}
+	obj.StartFrame = x.StartFrame
+	obj.NumFrames = x.NumFrames
+	for _, a := range x.Analogs {
+		obj.Analogs = append(obj.Analogs, RtMetricsAnalogObject(a))
+	}
+	return obj, nil
+}
+
+// RtMetricsRegistry keeps the most recent RT Metrics of each socket,
+// for as long as its basecaller runs and until the next one starts.
+type RtMetricsRegistry struct {
+	max int
+
+	mu      sync.Mutex
+	history map[string][]RtMetricsObject
+}
+
+// NewRtMetricsRegistry keeps up to max RT Metrics per socket.
+func NewRtMetricsRegistry(max int) *RtMetricsRegistry {
+	if max < 1 {
+		max = 1
+	}
+	return &RtMetricsRegistry{max: max, history: make(map[string][]RtMetricsObject)}
+}
+
+// Get returns the RT Metrics of socket id, oldest first.
+func (r *RtMetricsRegistry) Get(id string) []RtMetricsObject {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RtMetricsObject{}, r.history[id]...)
+}
+
+func (r *RtMetricsRegistry) add(id string, obj RtMetricsObject) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h := append(r.history[id], obj)
+	if len(h) > r.max {
+		h = h[len(h)-r.max:]
+	}
+	r.history[id] = h
+}
+
+func (r *RtMetricsRegistry) clear(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.history, id)
+}
+
+// Starts watching for the RT Metrics of the basecaller p on socket id,
+// from now until it exits.
+func (st *State) watchRtMetrics(id string, p *Process) {
+	st.RtMetrics.clear(id)
+	obj, ok := st.Sockets.Get(id)
+	if !ok || obj.Basecaller.BazUrl == "" {
+		return
+	}
+	path, err := st.Resolver.Path(obj.Basecaller.BazUrl)
+	if err != nil {
+		return
+	}
+	w := &rtMetricsWatcher{
+		id:        id,
+		bazUrl:    obj.Basecaller.BazUrl,
+		dir:       filepath.Dir(path),
+		urlPrefix: obj.Basecaller.BazUrl[:strings.LastIndex(obj.Basecaller.BazUrl, "/")+1],
+		sockets:   st.Sockets,
+		metrics:   st.RtMetrics,
+		done:      st.done,
+	}
+	go w.run(p, st.Config.OutputPollInterval)
+}
+
+// Follows the RT Metrics files of one basecaller.
+type rtMetricsWatcher struct {
+	id        string
+	bazUrl    string
+	dir       string
+	urlPrefix string
+	sockets   *SocketRegistry
+	metrics   *RtMetricsRegistry
+	done      <-chan struct{}
+
+	// Name of the newest file taken so far
+	last string
+}
+
+// Scans every interval until p is done, then once more for the files
+// written as it exited. It stops early if the state is closed.
+func (w *rtMetricsWatcher) run(p *Process, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.scan()
+		case <-p.Done():
+			w.scan()
+			return
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Takes the files that are newer than the last one taken, in the order
+// they were written. A file that does not parse, most likely because it
+// is still being written, is tried again on the next scan, unless a
+// newer one has appeared by then.
+func (w *rtMetricsWatcher) scan() {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && rtMetricsPattern.MatchString(e.Name()) && e.Name() > w.last {
+			names = append(names, e.Name())
+		}
+	}
+	// ReadDir sorts by name, which is the order they were written.
+	for i, name := range names {
+		obj, err := readRtMetrics(filepath.Join(w.dir, name), w.urlPrefix+name)
+		if err != nil {
+			if i == len(names)-1 {
+				return
+			}
+			continue
+		}
+		w.last = name
+		w.metrics.add(w.id, obj)
+		w.sockets.Update(w.id, func(s *SocketObject) error {
+			// Unless the socket has moved on to another basecaller
+			if s.Basecaller.BazUrl == w.bazUrl {
+				s.Basecaller.RtMetrics.Url = obj.Url
+			}
+			return nil
+		})
+	}
+}
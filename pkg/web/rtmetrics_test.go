@@ -0,0 +1,119 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeRtMetrics(t *testing.T, dir, stamp string, startFrame int) {
+	t.Helper()
+	xml := fmt.Sprintf(`<RTMetrics>
+  <StartFrame>%d</StartFrame>
+  <NumFrames>4096</NumFrames>
+  <Analog BaseLabel="A"><Baseline>12.5</Baseline><BaselineSigma>4.2</BaselineSigma><Snr>11.3</Snr><PulseRate>1.8</PulseRate><PulseWidth>9.6</PulseWidth></Analog>
+  <Analog BaseLabel="C"><Baseline>12.7</Baseline><BaselineSigma>4.1</BaselineSigma><Snr>9.8</Snr><PulseRate>1.6</PulseRate><PulseWidth>8.9</PulseWidth></Analog>
+</RTMetrics>
+`, startFrame)
+	if err := os.WriteFile(filepath.Join(dir, "rtmetrics_"+stamp+".xml"), []byte(xml), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadRtMetrics(t *testing.T) {
+	dir := t.TempDir()
+	writeRtMetrics(t, dir, "20210625_123456", 1024000)
+	obj, err := readRtMetrics(filepath.Join(dir, "rtmetrics_20210625_123456.xml"), "url")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Url != "url" || obj.Timestamp != "2021-06-25T12:34:56.000Z" || obj.StartFrame != 1024000 || obj.NumFrames != 4096 {
+		t.Errorf("got %+v", obj)
+	}
+	want := []RtMetricsAnalogObject{
+		{BaseLabel: A, Baseline: 12.5, BaselineSigma: 4.2, Snr: 11.3, PulseRate: 1.8, PulseWidth: 9.6},
+		{BaseLabel: C, Baseline: 12.7, BaselineSigma: 4.1, Snr: 9.8, PulseRate: 1.6, PulseWidth: 8.9},
+	}
+	if fmt.Sprint(obj.Analogs) != fmt.Sprint(want) {
+		t.Errorf("got %+v, want %+v", obj.Analogs, want)
+	}
+
+	bad := filepath.Join(dir, "rtmetrics_20210625_123500.xml")
+	os.WriteFile(bad, []byte("<RTMetrics><StartFrame>"), 0644)
+	if _, err := readRtMetrics(bad, "url"); err == nil {
+		t.Error("a truncated file parsed")
+	}
+}
+
+func TestRtMetricsHistory(t *testing.T) {
+	t.Setenv("FAKE_SLEEP", "0.2")
+	config := fakeConfig(t)
+	config.RtMetricsHistory = 2
+	router, st := newTestState(t, config)
+	var storage StorageObject
+	decode(t, serve(router, "POST", "/storages", `{"mid": "m1"}`), &storage)
+	dir := strings.TrimPrefix(storage.LinuxPath, "file:")
+	writeRtMetrics(t, dir, "20210625_123456", 0)
+	writeRtMetrics(t, dir, "20210625_123556", 4096)
+	// Still being written
+	os.WriteFile(filepath.Join(dir, "rtmetrics_20210625_123656.xml"), []byte("<RTMetrics>"), 0644)
+
+	body := strings.Replace(basecallerBody, "file:/data/pa/m123456_987654/thefile.baz", "http://localhost:23632/storages/m1/m1.baz", 1)
+	if w := serve(router, "POST", "/sockets/1/basecaller/start", body); w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	writeRtMetrics(t, dir, "20210625_123656", 8192)
+	obj := waitBasecaller(t, st, "1")
+	if want := "http://localhost:23632/storages/m1/rtmetrics_20210625_123656.xml"; obj.RtMetrics.Url != want {
+		t.Errorf("got url %q, want %q", obj.RtMetrics.Url, want)
+	}
+
+	var history []RtMetricsObject
+	w := serve(router, "GET", "/sockets/1/basecaller/rtmetrics", "")
+	decode(t, w, &history)
+	if len(history) != 2 || history[0].StartFrame != 4096 || history[1].StartFrame != 8192 {
+		t.Errorf("got %+v", history)
+	}
+
+	decode(t, serve(router, "GET", "/sockets/2/basecaller/rtmetrics", ""), &history)
+	if len(history) != 0 {
+		t.Errorf("socket 2 got %+v", history)
+	}
+	if w := serve(router, "GET", "/sockets/9/basecaller/rtmetrics", ""); w.Code != http.StatusNotFound {
+		t.Errorf("socket 9 got %d", w.Code)
+	}
+}
+
+func TestRtMetricsOfAdoptedBasecaller(t *testing.T) {
+	t.Setenv("FAKE_SLEEP", "10")
+	config := fakeConfig(t)
+	config.StateDir = t.TempDir()
+	router, st := newTestState(t, config)
+	var storage StorageObject
+	decode(t, serve(router, "POST", "/storages", `{"mid": "m1"}`), &storage)
+	dir := strings.TrimPrefix(storage.LinuxPath, "file:")
+	body := strings.Replace(basecallerBody, "file:/data/pa/m123456_987654/thefile.baz", "http://localhost:23632/storages/m1/m1.baz", 1)
+	if w := serve(router, "POST", "/sockets/1/basecaller/start", body); w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+
+	// pa-ws restarts, while the basecaller keeps going.
+	st.Close()
+	router, st = newTestState(t, config)
+	writeRtMetrics(t, dir, "20210625_123456", 0)
+	deadline := time.Now().Add(5 * time.Second)
+	var history []RtMetricsObject
+	for len(history) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		decode(t, serve(router, "GET", "/sockets/1/basecaller/rtmetrics", ""), &history)
+	}
+	if len(history) != 1 {
+		t.Errorf("got %+v", history)
+	}
+	serve(router, "POST", "/sockets/1/basecaller/stop", "")
+	waitBasecaller(t, st, "1")
+}
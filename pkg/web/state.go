@@ -0,0 +1,261 @@
+package web
+
+import (
+	"errors"
+	"pacb.com/seq/paws/pkg/resolver"
+	"sync"
+	"time"
+)
+
+// ErrSocketNotFound is returned for a socketId that was not configured.
+var ErrSocketNotFound = errors.New("socket not found")
+
+// State is the top-level, in-memory state of pa-ws. The handlers are
+// its methods.
+type State struct {
+	Config    Config
+	Sockets   *SocketRegistry
+	Processes *Supervisor
+	Storages  *StorageManager
+	Resolver  *resolver.Resolver
+	Frames    FrameSource
+	RtMetrics *RtMetricsRegistry
+
+	Postprimaries *PostprimaryRegistry
+	Queue         *PostprimaryQueue
+
+	// Where the state is kept across restarts, or nil
+	Store *StateStore
+
+	// Where changes are published for GET /events
+	Events *EventLog
+
+	// Where final objects are POSTed when their process completes
+	Webhooks *Webhooks
+
+	// When pa-ws started, for its uptime
+	Started time.Time
+
+	// Closed by Close, to stop the goroutines of the state
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewState builds the state for a freshly started pa-ws.
+func NewState(config Config) (*State, error) {
+	storages, err := NewStorageManager(config)
+	if err != nil {
+		return nil, err
+	}
+	frames, err := newFrameSource(config.FrameSource)
+	if err != nil {
+		return nil, err
+	}
+	events := NewEventLog(config.EventBufferSize)
+	webhooks := NewWebhooks(config)
+	sockets := NewSocketRegistry(config.SocketIds)
+	sockets.events, sockets.webhooks, sockets.apps = events, webhooks, socketApps
+	postprimaries := NewPostprimaryRegistry()
+	postprimaries.events, postprimaries.webhooks = events, webhooks
+	st := &State{
+		Config:    config,
+		Sockets:   sockets,
+		Processes: NewSupervisor(),
+		Storages:  storages,
+		Resolver:  resolver.New(storages),
+		Frames:    frames,
+		RtMetrics: NewRtMetricsRegistry(config.RtMetricsHistory),
+
+		Postprimaries: postprimaries,
+		Queue:         NewPostprimaryQueue(config.MaxPostprimaries, config.PostprimaryRssLimitGb),
+
+		Store:    NewStateStore(config.StateDir),
+		Events:   events,
+		Webhooks: webhooks,
+		Started:  time.Now(),
+		done:     make(chan struct{}),
+	}
+	storages.inUse = st.storageInUse
+	return st, nil
+}
+
+// Close writes what is left to write of the state, then stops its
+// goroutines: the one that keeps the snapshot, and those that watch
+// outputs, poll adopted processes and retry webhooks. Child processes
+// keep running, for the next pa-ws to adopt.
+func (st *State) Close() {
+	st.closeOnce.Do(func() {
+		st.Store.flush()
+		close(st.done)
+		st.Processes.Close()
+		st.Webhooks.Close()
+	})
+}
+
+// SocketRegistry owns one SocketObject per socket, keyed by socketId.
+// All access goes through its lock, so the handlers and the goroutines
+// watching child processes can share it.
+type SocketRegistry struct {
+	mu      sync.RWMutex
+	ids     []string
+	sockets map[string]*SocketObject
+
+	// Called after every update, without the lock
+	onChange func()
+
+	// Where changes in the status of apps are published, and final
+	// objects sent, or nil
+	events   *EventLog
+	webhooks *Webhooks
+	apps     []socketApp
+}
+
+// NewSocketRegistry creates a READY SocketObject for each id.
+func NewSocketRegistry(ids []string) *SocketRegistry {
+	r := &SocketRegistry{
+		sockets: make(map[string]*SocketObject),
+	}
+	for _, id := range ids {
+		if _, dup := r.sockets[id]; dup {
+			continue
+		}
+		r.ids = append(r.ids, id)
+		r.sockets[id] = newSocketObject(id)
+	}
+	return r
+}
+
+func newSocketObject(id string) *SocketObject {
+	obj := &SocketObject{SocketId: id}
+	obj.Darkcal.ProcessStatus = readyStatus()
+	obj.Loadingcal.ProcessStatus = readyStatus()
+	obj.Basecaller.ProcessStatus = readyStatus()
+	return obj
+}
+
+func readyStatus() ProcessStatusObject {
+	return ProcessStatusObject{
+		ExecutionStatus: Ready,
+		Timestamp:       timestamp(time.Now()),
+	}
+}
+
+// Claims an app for a launch, so a concurrent start sees it busy.
+func runningStatus() ProcessStatusObject {
+	return ProcessStatusObject{
+		ExecutionStatus: Running,
+		Timestamp:       timestamp(time.Now()),
+	}
+}
+
+// Replaces the socket object with obj, as restored from a snapshot.
+// It returns false if the socket is not configured.
+func (r *SocketRegistry) restore(obj SocketObject) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.sockets[obj.SocketId]; !ok {
+		return false
+	}
+	r.sockets[obj.SocketId] = &obj
+	return true
+}
+
+// Ids returns the socket ids, in configuration order.
+func (r *SocketRegistry) Ids() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]string(nil), r.ids...)
+}
+
+// Get returns a copy of the socket object.
+func (r *SocketRegistry) Get(id string) (SocketObject, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	obj, ok := r.sockets[id]
+	if !ok {
+		return SocketObject{}, false
+	}
+	return *obj, true
+}
+
+// Update calls f on the socket object while holding the write lock.
+// The error from f is passed back, and nothing is rolled back, so f
+// should check before it mutates.
+func (r *SocketRegistry) Update(id string, f func(*SocketObject) error) error {
+	var c changes
+	defer r.changed(&c)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	obj, ok := r.sockets[id]
+	if !ok {
+		return ErrSocketNotFound
+	}
+	before := appStatuses(r.apps, obj)
+	defer c.socket(r.apps, before, obj)
+	return f(obj)
+}
+
+// UpdateAll calls f on every socket object, in configuration order,
+// while holding the write lock.
+func (r *SocketRegistry) UpdateAll(f func([]*SocketObject) error) error {
+	var c changes
+	defer r.changed(&c)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	objs := make([]*SocketObject, len(r.ids))
+	before := make([][]ProcessStatusObject, len(r.ids))
+	for i, id := range r.ids {
+		objs[i] = r.sockets[id]
+		before[i] = appStatuses(r.apps, objs[i])
+	}
+	defer func() {
+		for i, obj := range objs {
+			c.socket(r.apps, before[i], obj)
+		}
+	}()
+	return f(objs)
+}
+
+// Publishes the changes of an update, and calls onChange.
+func (r *SocketRegistry) changed(c *changes) {
+	c.publish(r.events, r.webhooks)
+	if r.onChange != nil {
+		r.onChange()
+	}
+}
+
+// What an update changed, gathered under the lock of a registry and
+// published after it is released.
+type changes struct {
+	events   []EventObject
+	webhooks []webhook
+}
+
+// Adds the changes to the apps on socket s since before.
+func (c *changes) socket(apps []socketApp, before []ProcessStatusObject, s *SocketObject) {
+	c.events = append(c.events, socketEvents(apps, before, s)...)
+	c.webhooks = append(c.webhooks, socketWebhooks(apps, before, s)...)
+}
+
+// Adds the changes to obj since before.
+func (c *changes) postprimary(before, obj PostprimaryObject) {
+	c.events = append(c.events, postprimaryEvents(before, obj)...)
+	c.webhooks = append(c.webhooks, postprimaryWebhooks(before, obj)...)
+}
+
+func (c *changes) publish(events *EventLog, webhooks *Webhooks) {
+	if c == nil {
+		return
+	}
+	for _, e := range c.events {
+		events.publish(e)
+	}
+	for _, h := range c.webhooks {
+		webhooks.send(h)
+	}
+}
+
+// ISO8601 with milliseconds, e.g. 2017-01-31T01:59:49.103Z
+func timestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+}
@@ -0,0 +1,52 @@
+package web
+
+import "fmt"
+
+// Legal changes of ExecutionStatus. A "one shot" app is started from
+// READY, runs until COMPLETE, and must be reset to READY before it can
+// be started again. Since RUNNING cannot go to READY, a running app
+// has to be stopped before it is reset.
+var transitions = map[ExecutionStatusEnum][]ExecutionStatusEnum{
+	Unknown:  {Ready},
+	Ready:    {Ready, Running},
+	Running:  {Complete},
+	Complete: {Ready},
+}
+
+// TransitionError is the 409 response to a request that would make an
+// illegal change of ExecutionStatus.
+type TransitionError struct {
+	Message string `json:"message"`
+
+	// Path of the resource, e.g. /sockets/1/basecaller
+	Resource string `json:"resource"`
+
+	From ExecutionStatusEnum `json:"from"`
+	To   ExecutionStatusEnum `json:"to"`
+}
+
+func (e *TransitionError) Error() string {
+	return e.Message
+}
+
+// ResetConflictObject is the 409 response to a reset of several
+// resources, listing the ones that could not be reset. None were.
+type ResetConflictObject struct {
+	Message   string             `json:"message"`
+	Conflicts []*TransitionError `json:"conflicts"`
+}
+
+// Returns a *TransitionError unless from -> to is legal for resource.
+func checkTransition(resource string, from, to ExecutionStatusEnum) error {
+	for _, legal := range transitions[from] {
+		if legal == to {
+			return nil
+		}
+	}
+	return &TransitionError{
+		Message:  fmt.Sprintf("%s cannot go from %s to %s", resource, from, to),
+		Resource: resource,
+		From:     from,
+		To:       to,
+	}
+}
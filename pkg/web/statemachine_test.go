@@ -0,0 +1,96 @@
+package web
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCheckTransition(t *testing.T) {
+	tests := []struct {
+		from, to ExecutionStatusEnum
+		legal    bool
+	}{
+		{Unknown, Ready, true},
+		{Unknown, Running, false},
+		{Ready, Ready, true},
+		{Ready, Running, true},
+		{Ready, Complete, false},
+		{Running, Complete, true},
+		{Running, Ready, false},
+		{Complete, Ready, true},
+		{Complete, Running, false},
+	}
+	for _, tt := range tests {
+		err := checkTransition("/x", tt.from, tt.to)
+		if (err == nil) != tt.legal {
+			t.Errorf("%s -> %s: got %v", tt.from, tt.to, err)
+		}
+	}
+}
+
+func TestResetRunningBasecaller(t *testing.T) {
+	t.Setenv("FAKE_SLEEP", "10")
+	router, st := newTestState(t, fakeConfig(t))
+	serve(router, "POST", "/sockets/1/basecaller/start", basecallerBody)
+
+	w := serve(router, "POST", "/sockets/1/basecaller/reset", "")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("got %d", w.Code)
+	}
+	var terr TransitionError
+	decode(t, w, &terr)
+	if terr.Resource != "/sockets/1/basecaller" || terr.From != Running || terr.To != Ready {
+		t.Errorf("got %+v", terr)
+	}
+
+	serve(router, "POST", "/sockets/1/basecaller/stop", "")
+	waitBasecaller(t, st, "1")
+	if w := serve(router, "POST", "/sockets/1/basecaller/start", basecallerBody); w.Code != http.StatusConflict {
+		t.Errorf("start before reset: got %d", w.Code)
+	}
+	w = serve(router, "POST", "/sockets/1/basecaller/reset", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d", w.Code)
+	}
+	var obj SocketBasecallerObject
+	decode(t, w, &obj)
+	if obj.Mid != "" || obj.ProcessStatus.ExecutionStatus != Ready {
+		t.Errorf("not cleared: %+v", obj)
+	}
+}
+
+func TestResetSocketsIsAtomic(t *testing.T) {
+	t.Setenv("FAKE_SLEEP", "10")
+	router, st := newTestState(t, fakeConfig(t))
+	serve(router, "POST", "/sockets/1/basecaller/start", basecallerBody)
+	st.Sockets.Update("2", func(s *SocketObject) error {
+		s.Darkcal.Mid = "m2"
+		s.Darkcal.ProcessStatus.ExecutionStatus = Complete
+		return nil
+	})
+
+	w := serve(router, "POST", "/sockets/reset", "")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("got %d", w.Code)
+	}
+	var body ResetConflictObject
+	decode(t, w, &body)
+	if len(body.Conflicts) != 1 || body.Conflicts[0].Resource != "/sockets/1/basecaller" {
+		t.Errorf("got %+v", body)
+	}
+	if s, _ := st.Sockets.Get("2"); s.Darkcal.Mid != "m2" {
+		t.Errorf("socket 2 was reset anyway")
+	}
+
+	serve(router, "POST", "/sockets/1/basecaller/stop", "")
+	waitBasecaller(t, st, "1")
+	if w := serve(router, "POST", "/sockets/reset", ""); w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	for _, id := range []string{"1", "2"} {
+		s, _ := st.Sockets.Get(id)
+		if s.Basecaller.ProcessStatus.ExecutionStatus != Ready || s.Darkcal.Mid != "" {
+			t.Errorf("socket %s not reset: %+v", id, s)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package web
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Set at link time by the makefile, e.g.
+//
+//	go build -ldflags "-X pacb.com/seq/paws/pkg/web.gitHash=abc1234"
+var gitHash = "unknown"
+
+// Release of pa-ws; the build adds the git hash.
+const release = "0.1.0"
+
+// Version returns the release and the git hash it was built from.
+func Version() string {
+	return release + "+" + gitHash
+}
+
+// Human readable uptime, e.g. "2 days, 3 hours, 0 minutes, 12 seconds".
+func uptimeMessage(d time.Duration) string {
+	secs := int64(d / time.Second)
+	days, secs := secs/86400, secs%86400
+	hours, secs := secs/3600, secs%3600
+	minutes, secs := secs/60, secs%60
+	var parts []string
+	if days > 0 {
+		parts = append(parts, plural(days, "day"))
+	}
+	if days > 0 || hours > 0 {
+		parts = append(parts, plural(hours, "hour"))
+	}
+	if days > 0 || hours > 0 || minutes > 0 {
+		parts = append(parts, plural(minutes, "minute"))
+	}
+	parts = append(parts, plural(secs, "second"))
+	return strings.Join(parts, ", ")
+}
+
+func plural(n int64, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// The status of pa-ws as of now, with a summary of every socket and
+// counts of the child processes.
+func (st *State) pawsStatus(now time.Time) PawsStatusObject {
+	uptime := now.Sub(st.Started)
+	status := PawsStatusObject{
+		Uptime:        uptime.Seconds(),
+		UptimeMessage: uptimeMessage(uptime),
+		Time:          float64(now.UnixNano()) / 1e9,
+		Timestamp:     timestamp(now),
+		Version:       Version(),
+		Sockets:       []SocketSummaryObject{},
+	}
+	for _, id := range st.Sockets.Ids() {
+		obj, ok := st.Sockets.Get(id)
+		if !ok {
+			continue
+		}
+		status.Sockets = append(status.Sockets, SocketSummaryObject{
+			SocketId:   id,
+			Darkcal:    obj.Darkcal.ProcessStatus.ExecutionStatus,
+			Loadingcal: obj.Loadingcal.ProcessStatus.ExecutionStatus,
+			Basecaller: obj.Basecaller.ProcessStatus.ExecutionStatus,
+			Mid:        obj.Basecaller.Mid,
+		})
+	}
+	for _, key := range st.Processes.Running() {
+		switch {
+		case strings.HasPrefix(key, "postprimaries/"):
+			status.Processes.Postprimary++
+		case strings.HasSuffix(key, "/"+basecallerApp.name):
+			status.Processes.Basecaller++
+		case strings.HasSuffix(key, "/"+darkcalApp.name):
+			status.Processes.Darkcal++
+		case strings.HasSuffix(key, "/"+loadingcalApp.name):
+			status.Processes.Loadingcal++
+		}
+	}
+	status.Processes.QueuedPostprimaries = st.Queue.queuedCount()
+	return status
+}
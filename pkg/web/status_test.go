@@ -0,0 +1,56 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUptimeMessage(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		0:                            "0 seconds",
+		1500 * time.Millisecond:      "1 second",
+		61 * time.Second:             "1 minute, 1 second",
+		2 * time.Hour:                "2 hours, 0 minutes, 0 seconds",
+		(49*60*60 + 3) * time.Second: "2 days, 1 hour, 0 minutes, 3 seconds",
+	} {
+		if got := uptimeMessage(d); got != want {
+			t.Errorf("%v: got %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestGetStatus(t *testing.T) {
+	t.Setenv("FAKE_SLEEP", "10")
+	router := newTestRouter(t, fakeConfig(t))
+	serve(router, "POST", "/sockets/2/basecaller/start", basecallerBody)
+	defer serve(router, "POST", "/sockets/2/basecaller/stop", "")
+
+	w := serve(router, "GET", "/status", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d", w.Code)
+	}
+	var status PawsStatusObject
+	decode(t, w, &status)
+	if status.Uptime < 0 || status.Uptime > 60 || !strings.HasSuffix(status.UptimeMessage, "seconds") && !strings.HasSuffix(status.UptimeMessage, "second") {
+		t.Errorf("uptime %v %q", status.Uptime, status.UptimeMessage)
+	}
+	if now := float64(time.Now().Unix()); status.Time < now-60 || status.Time > now+60 {
+		t.Errorf("time %v", status.Time)
+	}
+	if _, err := time.Parse(time.RFC3339, status.Timestamp); err != nil || !strings.HasSuffix(status.Timestamp, "Z") {
+		t.Errorf("timestamp %q: %v", status.Timestamp, err)
+	}
+	if status.Version != Version() || !strings.Contains(status.Version, "+") {
+		t.Errorf("version %q", status.Version)
+	}
+	if len(status.Sockets) != 4 || status.Sockets[1].SocketId != "2" ||
+		status.Sockets[1].Basecaller != Running || status.Sockets[1].Mid != "m123456_987654" ||
+		status.Sockets[0].Basecaller != Ready {
+		t.Errorf("sockets %+v", status.Sockets)
+	}
+	if status.Processes != (ProcessCountsObject{Basecaller: 1}) {
+		t.Errorf("processes %+v", status.Processes)
+	}
+}
@@ -0,0 +1,450 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrStorageExists is returned when a MID already has storage.
+	ErrStorageExists = errors.New("storage already exists")
+
+	// ErrStorageNotFound is returned for a MID without storage.
+	ErrStorageNotFound = errors.New("storage not found")
+
+	// ErrStorageNotFreed is returned when deleting storage that still has files.
+	ErrStorageNotFreed = errors.New("storage must be freed before it is deleted")
+
+	// ErrStorageBusy is returned when storage is already being freed.
+	ErrStorageBusy = errors.New("storage is being freed")
+)
+
+// UnsafePathError is returned instead of removing a directory that is
+// not plainly inside a storage root.
+type UnsafePathError struct {
+	Path   string
+	Reason string
+}
+
+func (e *UnsafePathError) Error() string {
+	return "refusing to remove " + e.Path + ": " + e.Reason
+}
+
+// StorageInUseError is returned instead of freeing storage that a
+// process is using, or is queued to use.
+type StorageInUseError struct {
+	Mid    string
+	Reason string
+}
+
+func (e *StorageInUseError) Error() string {
+	return "storage for " + e.Mid + " is in use: " + e.Reason
+}
+
+// FreeReportObject is the result of freeing the storage of a movie.
+type FreeReportObject struct {
+	Mid string `json:"mid"`
+
+	// Bytes in the files that were removed
+	ReclaimedBytes int64 `json:"reclaimedBytes"`
+
+	ProcessStatus ProcessStatusObject `json:"processStatus"`
+}
+
+// A MID becomes a directory name, so it must be one plain path element.
+var validMid = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`)
+
+// StorageManager allocates one directory per movie under a set of root
+// partitions, and persists which MID went where in a JSON index file
+// in the first root.
+//
+// The ProcessStatus of a StorageObject follows the allocation:
+// READY once the directory exists, RUNNING while it is being freed,
+// and COMPLETE once it is gone.
+type StorageManager struct {
+	mu        sync.RWMutex
+	roots     []string
+	baseUrl   string
+	storages  map[string]*StorageObject
+	inventory *inventory
+
+	// Returns a *StorageInUseError if mid is in use. It is called with
+	// the lock held, so that nothing can start using the storage
+	// between the check and the free; see Dir.
+	inUse func(mid string) error
+}
+
+// NewStorageManager loads the index, if there is one yet.
+func NewStorageManager(config Config) (*StorageManager, error) {
+	if len(config.StorageRoots) == 0 {
+		return nil, errors.New("no storage roots configured")
+	}
+	m := &StorageManager{
+		roots:     config.StorageRoots,
+		baseUrl:   config.BaseUrl,
+		storages:  make(map[string]*StorageObject),
+		inventory: newInventory(config.StorageScanInterval),
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *StorageManager) indexPath() string {
+	return filepath.Join(m.roots[0], "storages.json")
+}
+
+func (m *StorageManager) auditPath() string {
+	return filepath.Join(m.roots[0], "storages_audit.log")
+}
+
+// The files the manager keeps in the first root, which no MID may
+// take the place of.
+func (m *StorageManager) ownFiles() []string {
+	var files []string
+	for _, path := range []string{m.indexPath(), m.auditPath()} {
+		files = append(files, path, path+".tmp")
+	}
+	return files
+}
+
+// Whether mid can name a storage: one plain path element, and not one
+// of the manager's own files.
+func (m *StorageManager) validMid(mid string) bool {
+	if !validMid.MatchString(mid) {
+		return false
+	}
+	dir := filepath.Join(m.roots[0], mid)
+	for _, own := range m.ownFiles() {
+		if dir == own {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *StorageManager) load() error {
+	b, err := os.ReadFile(m.indexPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var objs []*StorageObject
+	if err := json.Unmarshal(b, &objs); err != nil {
+		return fmt.Errorf("%s: %v", m.indexPath(), err)
+	}
+	for _, obj := range objs {
+		m.storages[obj.Mid] = obj
+	}
+	return nil
+}
+
+// Writes the index atomically. The caller holds the lock.
+func (m *StorageManager) save() error {
+	objs := make([]*StorageObject, 0, len(m.storages))
+	for _, mid := range m.sortedMids() {
+		objs = append(objs, m.storages[mid])
+	}
+	b, err := json.MarshalIndent(objs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.roots[0], 0755); err != nil {
+		return err
+	}
+	tmp := m.indexPath() + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.indexPath())
+}
+
+func (m *StorageManager) sortedMids() []string {
+	mids := make([]string, 0, len(m.storages))
+	for mid := range m.storages {
+		mids = append(mids, mid)
+	}
+	sort.Strings(mids)
+	return mids
+}
+
+// Mids returns the MIDs with storage, sorted.
+func (m *StorageManager) Mids() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.sortedMids()
+}
+
+// Get returns a copy of the storage object for mid.
+func (m *StorageManager) Get(mid string) (StorageObject, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	obj, ok := m.storages[mid]
+	if !ok {
+		return StorageObject{}, false
+	}
+	return *obj, true
+}
+
+// Report returns the storage object for mid with its current files,
+// and the space on the partition that holds them.
+func (m *StorageManager) Report(mid string) (StorageObject, error) {
+	obj, ok := m.Get(mid)
+	if !ok {
+		return obj, ErrStorageNotFound
+	}
+	if obj.ProcessStatus.ExecutionStatus != Ready {
+		return obj, nil
+	}
+	dir := storageDir(obj)
+	files, err := m.inventory.files(dir, obj.RootUrl)
+	if err != nil {
+		return obj, err
+	}
+	obj.Files = files
+	total, free, err := diskSpace(dir)
+	if err != nil {
+		return obj, err
+	}
+	obj.Space = []StorageDiskReportObject{{TotalSpace: total, FreeSpace: free}}
+	return obj, nil
+}
+
+// Dir returns the directory allocated to mid, as long as it is not
+// being freed or freed. It makes the StorageManager a resolver.Storages.
+func (m *StorageManager) Dir(mid string) (string, error) {
+	obj, ok := m.Get(mid)
+	if !ok {
+		return "", ErrStorageNotFound
+	}
+	switch obj.ProcessStatus.ExecutionStatus {
+	case Running:
+		return "", fmt.Errorf("storage for %s is being freed", mid)
+	case Complete:
+		return "", fmt.Errorf("storage for %s has been freed", mid)
+	}
+	return storageDir(obj), nil
+}
+
+// The directory of a storage object.
+func storageDir(obj StorageObject) string {
+	return strings.TrimPrefix(obj.LinuxPath, "file:")
+}
+
+// Create allocates a directory for req.Mid on the root with the most
+// free space, and fills in the URLs and path.
+func (m *StorageManager) Create(req StorageObject) (StorageObject, error) {
+	if !m.validMid(req.Mid) {
+		return StorageObject{}, fmt.Errorf("invalid mid %q", req.Mid)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.storages[req.Mid]; ok {
+		return StorageObject{}, ErrStorageExists
+	}
+	dir := filepath.Join(m.pickRoot(), req.Mid)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return StorageObject{}, err
+	}
+	obj := &StorageObject{
+		Mid:       req.Mid,
+		RootUrl:   m.baseUrl + "/storages/" + req.Mid,
+		LinuxPath: "file:" + dir,
+		LogUrl:    req.LogUrl,
+		LogLevel:  req.LogLevel,
+		ProcessStatus: ProcessStatusObject{
+			ExecutionStatus: Ready,
+			Timestamp:       timestamp(time.Now()),
+		},
+	}
+	if obj.LogUrl == "" {
+		obj.LogUrl = obj.RootUrl + "/storage.log"
+	}
+	if obj.LogLevel == "" {
+		obj.LogLevel = Info
+	}
+	m.storages[obj.Mid] = obj
+	if err := m.save(); err != nil {
+		delete(m.storages, obj.Mid)
+		return StorageObject{}, err
+	}
+	return *obj, nil
+}
+
+// The root with the most available space. A root that cannot be
+// measured (e.g. not mounted) is only used if none can be.
+func (m *StorageManager) pickRoot() string {
+	best, bestFree := m.roots[0], int64(-1)
+	for _, root := range m.roots {
+		_, free, err := diskSpace(root)
+		if err == nil && free > bestFree {
+			best, bestFree = root, free
+		}
+	}
+	return best
+}
+
+// Total and available bytes of the file system holding path.
+func diskSpace(path string) (total, free int64, err error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return 0, 0, err
+	}
+	return int64(fs.Blocks) * int64(fs.Bsize), int64(fs.Bavail) * int64(fs.Bsize), nil
+}
+
+// Free removes every file and directory of the movie, after checking
+// that it is not in use, and that the directory is safely inside a
+// storage root. Freeing storage that is already freed reclaims nothing.
+func (m *StorageManager) Free(mid string) (FreeReportObject, error) {
+	m.mu.Lock()
+	obj, ok := m.storages[mid]
+	if !ok {
+		m.mu.Unlock()
+		return FreeReportObject{}, ErrStorageNotFound
+	}
+	switch obj.ProcessStatus.ExecutionStatus {
+	case Running:
+		m.mu.Unlock()
+		return FreeReportObject{}, ErrStorageBusy
+	case Complete:
+		report := FreeReportObject{Mid: mid, ProcessStatus: obj.ProcessStatus}
+		m.mu.Unlock()
+		return report, nil
+	}
+	if m.inUse != nil {
+		if err := m.inUse(mid); err != nil {
+			m.mu.Unlock()
+			return FreeReportObject{}, err
+		}
+	}
+	obj.ProcessStatus = runningStatus()
+	dir := storageDir(*obj)
+	m.mu.Unlock()
+
+	reclaimed, err := m.removeDir(dir)
+	m.audit("free", mid, dir, reclaimed, err)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inventory.forget(dir)
+	if err != nil {
+		obj.ProcessStatus = readyStatus()
+		return FreeReportObject{}, err
+	}
+	obj.Files = nil
+	obj.Space = nil
+	obj.ProcessStatus = ProcessStatusObject{
+		ExecutionStatus:  Complete,
+		CompletionStatus: CompletionSuccess,
+		Timestamp:        timestamp(time.Now()),
+	}
+	report := FreeReportObject{Mid: mid, ReclaimedBytes: reclaimed, ProcessStatus: obj.ProcessStatus}
+	return report, m.save()
+}
+
+// Delete drops the storage resource for mid, once it has been freed.
+func (m *StorageManager) Delete(mid string) (StorageObject, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, ok := m.storages[mid]
+	if !ok {
+		return StorageObject{}, ErrStorageNotFound
+	}
+	if obj.ProcessStatus.ExecutionStatus != Complete {
+		return StorageObject{}, ErrStorageNotFreed
+	}
+	delete(m.storages, mid)
+	err := m.save()
+	m.audit("delete", mid, storageDir(*obj), 0, err)
+	return *obj, err
+}
+
+// Removes dir, returning the bytes in the files removed. Symlinks are
+// removed, not followed.
+func (m *StorageManager) removeDir(dir string) (int64, error) {
+	if err := m.checkSafe(dir); err != nil {
+		return 0, err
+	}
+	var size int64
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	return size, os.RemoveAll(dir)
+}
+
+// A movie directory must be a real directory (not a symlink) directly
+// inside one of the roots, with no ".." on the way.
+func (m *StorageManager) checkSafe(dir string) error {
+	if !filepath.IsAbs(dir) || filepath.Clean(dir) != dir {
+		return &UnsafePathError{dir, "not a clean absolute path"}
+	}
+	for _, part := range strings.Split(dir, string(filepath.Separator)) {
+		if part == ".." {
+			return &UnsafePathError{dir, `contains ".."`}
+		}
+	}
+	if fi, err := os.Lstat(dir); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return &UnsafePathError{dir, "is a symlink"}
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(dir))
+	if err != nil {
+		return err
+	}
+	for _, root := range m.roots {
+		if real, err := filepath.EvalSymlinks(root); err == nil && real == parent {
+			return nil
+		}
+	}
+	return &UnsafePathError{dir, "not in a storage root"}
+}
+
+// Appends a line to the audit log of removals. The audit log must not
+// stop the removal itself, so its own errors are only logged.
+func (m *StorageManager) audit(action, mid, dir string, bytes int64, err error) {
+	entry := struct {
+		Timestamp string `json:"timestamp"`
+		Action    string `json:"action"`
+		Mid       string `json:"mid"`
+		Path      string `json:"path"`
+		Bytes     int64  `json:"bytes"`
+		Error     string `json:"error,omitempty"`
+	}{timestamp(time.Now()), action, mid, dir, bytes, ""}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	b, _ := json.Marshal(entry)
+	f, ferr := os.OpenFile(m.auditPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if ferr != nil {
+		log.Printf("audit: %v: %s", ferr, b)
+		return
+	}
+	defer f.Close()
+	if _, ferr := f.Write(append(b, '\n')); ferr != nil {
+		log.Printf("audit: %v: %s", ferr, b)
+	}
+}
@@ -0,0 +1,219 @@
+package web
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCreateStorage(t *testing.T) {
+	config := testConfig(t)
+	router := newTestRouter(t, config)
+
+	w := serve(router, "POST", "/storages", `{"mid": "m123456_987654"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	var obj StorageObject
+	decode(t, w, &obj)
+	if obj.RootUrl != "http://localhost:5000/storages/m123456_987654" {
+		t.Errorf("got rootUrl %q", obj.RootUrl)
+	}
+	if obj.LogUrl != obj.RootUrl+"/storage.log" {
+		t.Errorf("got logUrl %q", obj.LogUrl)
+	}
+	if obj.ProcessStatus.ExecutionStatus != Ready {
+		t.Errorf("got %+v", obj.ProcessStatus)
+	}
+	dir := strings.TrimPrefix(obj.LinuxPath, "file:")
+	if !strings.HasPrefix(dir, config.StorageRoots[0]) {
+		t.Errorf("%s is not under %s", dir, config.StorageRoots[0])
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		t.Errorf("no directory: %v", err)
+	}
+
+	if w := serve(router, "POST", "/storages", `{"mid": "m123456_987654"}`); w.Code != http.StatusConflict {
+		t.Errorf("duplicate: got %d", w.Code)
+	}
+	serve(router, "POST", "/storages", `{"mid": "m0"}`)
+	var mids []string
+	decode(t, serve(router, "GET", "/storages", ""), &mids)
+	if !reflect.DeepEqual(mids, []string{"m0", "m123456_987654"}) {
+		t.Errorf("got %v", mids)
+	}
+	if w := serve(router, "GET", "/storages/m123456_987654", ""); w.Code != http.StatusOK {
+		t.Errorf("got %d", w.Code)
+	}
+	if w := serve(router, "GET", "/storages/m9", ""); w.Code != http.StatusNotFound {
+		t.Errorf("got %d", w.Code)
+	}
+}
+
+func TestCreateStorageInvalidMid(t *testing.T) {
+	router, st := newTestState(t, testConfig(t))
+	for _, mid := range []string{"", "..", ".hidden", "a/b", "storages.json", "storages.json.tmp", "storages_audit.log"} {
+		if w := serve(router, "POST", "/storages", `{"mid": "`+mid+`"}`); w.Code != http.StatusBadRequest {
+			t.Errorf("%q: got %d", mid, w.Code)
+		}
+	}
+	// The index is still a file, and takes the next storage.
+	if w := serve(router, "POST", "/storages", `{"mid": "m1"}`); w.Code != http.StatusCreated {
+		t.Errorf("got %d", w.Code)
+	}
+	if _, err := NewStorageManager(st.Config); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStorageIndexPersists(t *testing.T) {
+	roots := []string{t.TempDir(), t.TempDir()}
+	config := testConfig(t)
+	config.StorageRoots = roots
+	m, err := NewStorageManager(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := m.Create(StorageObject{Mid: "m1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err = NewStorageManager(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, ok := m.Get("m1")
+	if !ok || !reflect.DeepEqual(loaded, created) {
+		t.Errorf("got %+v, want %+v", loaded, created)
+	}
+}
+
+func TestFreeThenDeleteStorage(t *testing.T) {
+	config := testConfig(t)
+	router := newTestRouter(t, config)
+	var obj StorageObject
+	decode(t, serve(router, "POST", "/storages", `{"mid": "m1"}`), &obj)
+	dir := strings.TrimPrefix(obj.LinuxPath, "file:")
+	writeFile(t, filepath.Join(dir, "thefile.baz"), "12345")
+	writeFile(t, filepath.Join(dir, "out", "m1.subreads.bam"), "1")
+
+	if w := serve(router, "DELETE", "/storages/m1", ""); w.Code != http.StatusConflict {
+		t.Errorf("delete before free: got %d", w.Code)
+	}
+	w := serve(router, "POST", "/storages/m1/free", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	var report FreeReportObject
+	decode(t, w, &report)
+	if report.ReclaimedBytes != 6 || report.ProcessStatus.ExecutionStatus != Complete {
+		t.Errorf("got %+v", report)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("%s still there: %v", dir, err)
+	}
+	decode(t, serve(router, "POST", "/storages/m1/free", ""), &report)
+	if report.ReclaimedBytes != 0 {
+		t.Errorf("freed twice: %+v", report)
+	}
+
+	if w := serve(router, "DELETE", "/storages/m1", ""); w.Code != http.StatusOK {
+		t.Errorf("got %d", w.Code)
+	}
+	if w := serve(router, "GET", "/storages/m1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("got %d", w.Code)
+	}
+	b, err := os.ReadFile(filepath.Join(config.StorageRoots[0], "storages_audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(b)), "\n"); len(lines) != 2 ||
+		!strings.Contains(lines[0], `"action":"free"`) || !strings.Contains(lines[0], `"bytes":6`) ||
+		!strings.Contains(lines[1], `"action":"delete"`) {
+		t.Errorf("got audit log:\n%s", b)
+	}
+}
+
+func TestFreeStorageInUse(t *testing.T) {
+	router, st := newTestState(t, testConfig(t))
+	serve(router, "POST", "/storages", `{"mid": "m1"}`)
+	st.Sockets.Update("3", func(s *SocketObject) error {
+		s.Basecaller.Mid = "m1"
+		s.Basecaller.ProcessStatus.ExecutionStatus = Running
+		return nil
+	})
+	if w := serve(router, "POST", "/storages/m1/free", ""); w.Code != http.StatusConflict {
+		t.Errorf("got %d", w.Code)
+	}
+}
+
+func TestFreeStorageUsedByUrl(t *testing.T) {
+	router, st := newTestState(t, testConfig(t))
+	serve(router, "POST", "/storages", `{"mid": "m1"}`)
+	st.Sockets.Update("3", func(s *SocketObject) error {
+		s.Basecaller.Mid = "m2"
+		s.Basecaller.BazUrl = "http://localhost:23632/storages/m1/m2.baz"
+		s.Basecaller.ProcessStatus.ExecutionStatus = Running
+		return nil
+	})
+	w := serve(router, "POST", "/storages/m1/free", "")
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "basecaller on socket 3") {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestFreeStorageOfQueuedPostprimary(t *testing.T) {
+	router, st := newTestState(t, testConfig(t))
+	serve(router, "POST", "/storages", `{"mid": "m1"}`)
+	st.Postprimaries.add(PostprimaryObject{
+		Mid:           "m2",
+		BazFileUrl:    "http://localhost:23632/storages/m1/m2.baz",
+		ProcessStatus: readyStatus(),
+	}, nil)
+	if w := serve(router, "POST", "/storages/m1/free", ""); w.Code != http.StatusConflict {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestStartRefusesStorageBeingFreed(t *testing.T) {
+	router, st := newTestState(t, fakeConfig(t))
+	serve(router, "POST", "/storages", `{"mid": "m1"}`)
+	st.Storages.storages["m1"].ProcessStatus = runningStatus()
+	body := `{"mid": "m1", "calibFileUrl": "http://localhost:23632/storages/m1/darkcal.h5"}`
+	w := serve(router, "POST", "/sockets/1/darkcal/start", body)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "being freed") {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestFreeRefusesEscapes(t *testing.T) {
+	router, st := newTestState(t, testConfig(t))
+	outside := t.TempDir()
+	writeFile(t, filepath.Join(outside, "precious"), "x")
+
+	var obj StorageObject
+	decode(t, serve(router, "POST", "/storages", `{"mid": "m1"}`), &obj)
+	dir := strings.TrimPrefix(obj.LinuxPath, "file:")
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, dir); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(router, "POST", "/storages/m1/free", ""); w.Code != http.StatusForbidden {
+		t.Errorf("symlink: got %d", w.Code)
+	}
+
+	serve(router, "POST", "/storages", `{"mid": "m2"}`)
+	st.Storages.storages["m2"].LinuxPath = "file:" + dir + "/../../" + filepath.Base(outside)
+	if w := serve(router, "POST", "/storages/m2/free", ""); w.Code != http.StatusForbidden {
+		t.Errorf("dot-dot: got %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "precious")); err != nil {
+		t.Errorf("removed outside the root: %v", err)
+	}
+}
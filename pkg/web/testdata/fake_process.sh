@@ -0,0 +1,33 @@
+#!/bin/sh
+# Stands in for smrt_basecaller and the other executables in tests.
+#   FAKE_ARGS     file to append the arguments to, one per line
+#   FAKE_OUTPUTS  files to create, separated by spaces
+#   FAKE_STDOUT   file to copy to stdout
+#   FAKE_LINES    number of lines to print then, a twentieth of a second apart
+#   FAKE_SLEEP    seconds to run before exiting
+#   FAKE_EXIT     exit code
+#   FAKE_TRAP     if set, ignore SIGTERM
+if [ -n "$FAKE_TRAP" ]; then
+    trap '' TERM
+fi
+if [ -n "$FAKE_ARGS" ]; then
+    printf '%s\n' "$@" >> "$FAKE_ARGS"
+fi
+for f in $FAKE_OUTPUTS; do
+    : > "$f"
+done
+if [ -n "$FAKE_STDOUT" ]; then
+    cat "$FAKE_STDOUT"
+fi
+if [ -n "$FAKE_LINES" ]; then
+    i=1
+    while [ "$i" -le "$FAKE_LINES" ]; do
+        echo "line $i"
+        sleep 0.05
+        i=$((i + 1))
+    done
+fi
+if [ -n "$FAKE_SLEEP" ]; then
+    sleep "$FAKE_SLEEP"
+fi
+exit "${FAKE_EXIT:-0}"
@@ -0,0 +1,168 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Decodes a single JSON value from r into v. If strict, fields that v
+// does not have are an error.
+func decodeJSON(r io.Reader, v interface{}, strict bool) error {
+	dec := json.NewDecoder(r)
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("more than one JSON value")
+	}
+	return nil
+}
+
+// FieldError is one problem with a request body, at the path of the
+// field within it, e.g. "analogs[2].baseLabel".
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ErrorObject is the body of an error response, with the problems of
+// an invalid request, if any. Some conflicts (409) carry more.
+type ErrorObject struct {
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors,omitempty"`
+}
+
+// ValidationError lists every problem found with a request body.
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return "invalid request: " + strings.Join(msgs, "; ")
+}
+
+// Collects FieldErrors as a request body is checked.
+type validator struct {
+	errs []FieldError
+}
+
+func (v *validator) check(ok bool, field, format string, args ...interface{}) {
+	if !ok {
+		v.errs = append(v.errs, FieldError{field, fmt.Sprintf(format, args...)})
+	}
+}
+
+// Returns a *ValidationError, or nil if nothing failed.
+func (v *validator) err() error {
+	if v.errs == nil {
+		return nil
+	}
+	return &ValidationError{v.errs}
+}
+
+// Size of a sensor chip, in ZMWs.
+type chipLayout struct {
+	rows, cols int32
+}
+
+// Chip layouts whose size is known. ROIs for other layouts are only
+// checked for shape.
+var chipLayouts = map[string]chipLayout{
+	"Minesweeper1.0": {2048, 1980},
+}
+
+// A ROI is [row, col, rows, cols]: its origin and size.
+func (v *validator) roi(field string, roi []int32, layout string) {
+	if roi == nil {
+		return
+	}
+	if len(roi) != 4 {
+		v.check(false, field, "must be 4 integers [row, col, rows, cols], not %d", len(roi))
+		return
+	}
+	v.check(roi[0] >= 0, field+"[0]", "row must not be negative")
+	v.check(roi[1] >= 0, field+"[1]", "col must not be negative")
+	v.check(roi[2] > 0, field+"[2]", "rows must be positive")
+	v.check(roi[3] > 0, field+"[3]", "cols must be positive")
+	if chip, ok := chipLayouts[layout]; ok {
+		// In int64, so that origin plus size cannot wrap around.
+		row, col, rows, cols := int64(roi[0]), int64(roi[1]), int64(roi[2]), int64(roi[3])
+		v.check(row+rows <= int64(chip.rows), field, "rows %d thru %d are outside %s, which has %d", row, row+rows-1, layout, chip.rows)
+		v.check(col+cols <= int64(chip.cols), field, "cols %d thru %d are outside %s, which has %d", col, col+cols-1, layout, chip.cols)
+	}
+}
+
+// A kernel is a square matrix with an odd size, so it has a center.
+func (v *validator) kernel(field string, m [][]float64) {
+	if m == nil {
+		return
+	}
+	v.check(len(m)%2 == 1, field, "must have an odd number of rows, not %d", len(m))
+	for i, row := range m {
+		v.check(len(row) == len(m), fmt.Sprintf("%s[%d]", field, i), "must have %d values, as the matrix is square, not %d", len(m), len(row))
+	}
+}
+
+func (v *validator) logLevel(level LogLevelEnum) {
+	switch level {
+	case "", Debug, Info, Warn, Error:
+	default:
+		v.check(false, "logLevel", "must be one of DEBUG, INFO, WARN or ERROR, not %q", level)
+	}
+}
+
+func (obj SocketBasecallerObject) validate() error {
+	var v validator
+	v.roi("sequencingRoi", obj.SequencingRoi, obj.Chiplayout)
+	v.roi("traceFileRoi", obj.TraceFileRoi, obj.Chiplayout)
+	v.kernel("pixelSpreadFunction", obj.PixelSpreadFunction)
+	v.kernel("crosstalkFilter", obj.CrosstalkFilter)
+	seen := make(map[BaseLabelEnum]int)
+	for i, analog := range obj.Analogs {
+		field := fmt.Sprintf("analogs[%d].baseLabel", i)
+		switch analog.BaseLabel {
+		case A, C, G, T:
+			if j, dup := seen[analog.BaseLabel]; dup {
+				v.check(false, field, "%s is already the label of analogs[%d]", analog.BaseLabel, j)
+			}
+			seen[analog.BaseLabel] = i
+		default:
+			v.check(false, field, "must be one of A, C, G or T, not %q", analog.BaseLabel)
+		}
+	}
+	v.check(obj.ExpectedFrameRate > 0, "expectedFrameRate", "must be positive")
+	v.logLevel(obj.LogLevel)
+	v.callbackUrl(obj.CallbackUrl)
+	return v.err()
+}
+
+func (obj SocketDarkcalObject) validate() error {
+	var v validator
+	v.logLevel(obj.LogLevel)
+	v.callbackUrl(obj.CallbackUrl)
+	return v.err()
+}
+
+func (obj SocketLoadingcalObject) validate() error {
+	var v validator
+	v.logLevel(obj.LogLevel)
+	v.callbackUrl(obj.CallbackUrl)
+	return v.err()
+}
+
+func (obj PostprimaryObject) validate() error {
+	var v validator
+	v.logLevel(obj.LogLevel)
+	v.callbackUrl(obj.CallbackUrl)
+	return v.err()
+}
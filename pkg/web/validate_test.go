@@ -0,0 +1,94 @@
+package web
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestValidateBasecaller(t *testing.T) {
+	obj := SocketBasecallerObject{
+		Chiplayout:          "Minesweeper1.0",
+		SequencingRoi:       []int32{0, 0, 2048, 1980},
+		TraceFileRoi:        []int32{0, 0, 256, 32},
+		PixelSpreadFunction: [][]float64{{0, 0.1, 0}, {0.1, 0.6, 0.1}, {0, 0.1, 0}},
+		Analogs:             []AnalogObject{{BaseLabel: A}, {BaseLabel: C}, {BaseLabel: G}, {BaseLabel: T}},
+		ExpectedFrameRate:   100,
+	}
+	if err := obj.validate(); err != nil {
+		t.Errorf("valid: %v", err)
+	}
+
+	obj.SequencingRoi = []int32{0, 100, 2048, 1980}
+	obj.TraceFileRoi = []int32{0, 0, 256}
+	obj.PixelSpreadFunction = [][]float64{{1, 0}, {0, 1}}
+	obj.CrosstalkFilter = [][]float64{{1}, {0}, {0, 1, 0}}
+	obj.Analogs = []AnalogObject{{BaseLabel: A}, {BaseLabel: N}, {BaseLabel: A}}
+	obj.ExpectedFrameRate = 0
+	obj.LogLevel = "VERBOSE"
+	err, ok := obj.validate().(*ValidationError)
+	if !ok {
+		t.Fatalf("got %v", err)
+	}
+	var fields []string
+	for _, fe := range err.Errors {
+		fields = append(fields, fe.Field)
+	}
+	want := []string{
+		"sequencingRoi",
+		"traceFileRoi",
+		"pixelSpreadFunction",
+		"crosstalkFilter[0]",
+		"crosstalkFilter[1]",
+		"analogs[1].baseLabel",
+		"analogs[2].baseLabel",
+		"expectedFrameRate",
+		"logLevel",
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("got %v", err.Errors)
+	}
+}
+
+func TestValidateRoiOverflow(t *testing.T) {
+	for _, roi := range [][]int32{
+		{2147483000, 0, 1000, 10},
+		{0, 2147483000, 10, 1000},
+		{0, 0, 2147483647, 2147483647},
+	} {
+		obj := SocketBasecallerObject{
+			Chiplayout:        "Minesweeper1.0",
+			SequencingRoi:     roi,
+			ExpectedFrameRate: 100,
+		}
+		err, ok := obj.validate().(*ValidationError)
+		if !ok || len(err.Errors) == 0 || err.Errors[0].Field != "sequencingRoi" {
+			t.Errorf("%v: got %v", roi, obj.validate())
+		}
+	}
+}
+
+func TestStartInvalidBasecaller(t *testing.T) {
+	router, st := newTestState(t, fakeConfig(t))
+	w := serve(router, "POST", "/sockets/1/basecaller/start", `{
+		"analogs": [{"baseLabel": "A"}, {"baseLabel": "C"}, {"baseLabel": "X"}],
+		"expectedFrameRate": 100
+	}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("got %d", w.Code)
+	}
+	var body struct {
+		Errors []FieldError `json:"errors"`
+	}
+	decode(t, w, &body)
+	if len(body.Errors) != 1 || body.Errors[0].Field != "analogs[2].baseLabel" {
+		t.Errorf("got %+v", body.Errors)
+	}
+	if s, _ := st.Sockets.Get("1"); s.Basecaller.ProcessStatus.ExecutionStatus != Ready {
+		t.Errorf("got %+v", s.Basecaller.ProcessStatus)
+	}
+
+	if w := serve(router, "POST", "/sockets/1/darkcal/start", `{"logLevel": "LOUD"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("darkcal got %d", w.Code)
+	}
+}
@@ -0,0 +1,218 @@
+package web
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Statuses of a WebhookDeliveryObject.
+const (
+	DeliveryPending   = "PENDING"
+	DeliveryDelivered = "DELIVERED"
+	DeliveryFailed    = "FAILED"
+)
+
+// How many deliveries GET /webhooks lists, the latest.
+const webhookLogSize = 200
+
+// A final object to be POSTed to its callbackUrl.
+type webhook struct {
+	url      string
+	resource string
+	mid      string
+	body     []byte
+}
+
+// Webhooks POSTs the final objects of completed processes to their
+// callbackUrl, in the background, retrying with exponential backoff,
+// and keeps a log of the latest deliveries.
+type Webhooks struct {
+	client  *http.Client
+	secret  []byte
+	retries int
+	backoff time.Duration
+
+	mu         sync.Mutex
+	last       int64 // id of the latest delivery
+	deliveries []*WebhookDeliveryObject
+
+	// Closed to stop retrying
+	quit      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewWebhooks delivers as the webhook settings of config say.
+func NewWebhooks(config Config) *Webhooks {
+	return &Webhooks{
+		client:  &http.Client{Timeout: config.WebhookTimeout},
+		secret:  []byte(config.WebhookSecret),
+		retries: config.WebhookRetries,
+		backoff: config.WebhookBackoff,
+		quit:    make(chan struct{}),
+	}
+}
+
+// Close gives up on the deliveries that are waiting to be retried; they
+// stay PENDING.
+func (w *Webhooks) Close() {
+	w.closeOnce.Do(func() { close(w.quit) })
+}
+
+// Starts delivering h. Sending with nil Webhooks does nothing, so
+// registries without them need not check.
+func (w *Webhooks) send(h webhook) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.last++
+	d := &WebhookDeliveryObject{
+		Id:        w.last,
+		Url:       h.url,
+		Resource:  h.resource,
+		Mid:       h.mid,
+		Status:    DeliveryPending,
+		Timestamp: timestamp(time.Now()),
+	}
+	w.deliveries = append(w.deliveries, d)
+	if len(w.deliveries) > webhookLogSize {
+		w.deliveries = w.deliveries[len(w.deliveries)-webhookLogSize:]
+	}
+	w.mu.Unlock()
+	go w.deliver(d, h.body)
+}
+
+// Tries to POST body, up to 1+retries times.
+func (w *Webhooks) deliver(d *WebhookDeliveryObject, body []byte) {
+	wait := w.backoff
+	for attempt := 0; ; attempt++ {
+		code, err := w.post(d, body)
+		w.mu.Lock()
+		d.Attempts++
+		d.ResponseCode = int32(code)
+		d.Error = ""
+		if err != nil {
+			d.Error = err.Error()
+		}
+		d.Timestamp = timestamp(time.Now())
+		switch {
+		case err == nil:
+			d.Status = DeliveryDelivered
+		case attempt == w.retries:
+			d.Status = DeliveryFailed
+		}
+		status := d.Status
+		w.mu.Unlock()
+		if status != DeliveryPending {
+			return
+		}
+		select {
+		case <-time.After(wait):
+		case <-w.quit:
+			return
+		}
+		wait *= 2
+	}
+}
+
+// POSTs body once, returning the status of the response, if any, and
+// an error unless it is a 2xx.
+func (w *Webhooks) post(d *WebhookDeliveryObject, body []byte) (int, error) {
+	req, err := http.NewRequest("POST", d.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Paws-Delivery", strconv.FormatInt(d.Id, 10))
+	req.Header.Set("X-Paws-Resource", d.Resource)
+	if len(w.secret) > 0 {
+		req.Header.Set("X-Paws-Signature", sign(w.secret, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, fmt.Errorf("%s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// The X-Paws-Signature of body: "sha256=" and the hex HMAC-SHA256 of
+// body, keyed by the secret.
+func sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Deliveries returns the latest deliveries of mid, or of every MID if
+// empty, oldest first.
+func (w *Webhooks) Deliveries(mid string) []WebhookDeliveryObject {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	list := []WebhookDeliveryObject{}
+	for _, d := range w.deliveries {
+		if mid == "" || d.Mid == mid {
+			list = append(list, *d)
+		}
+	}
+	return list
+}
+
+// Webhooks for the apps on socket s that have completed since before.
+// The bodies are marshalled here, while the caller holds the lock.
+func socketWebhooks(apps []socketApp, before []ProcessStatusObject, s *SocketObject) []webhook {
+	var hooks []webhook
+	for i, app := range apps {
+		common := app.common(s)
+		if common.CallbackUrl == "" || !completed(before[i], *app.status(s)) {
+			continue
+		}
+		hooks = append(hooks, webhook{
+			url:      common.CallbackUrl,
+			resource: app.resource(s.SocketId),
+			mid:      common.Mid,
+			body:     []byte(mustMarshal(app.object(s))),
+		})
+	}
+	return hooks
+}
+
+// The webhook for obj, if it has completed since before.
+func postprimaryWebhooks(before, obj PostprimaryObject) []webhook {
+	if obj.CallbackUrl == "" || !completed(before.ProcessStatus, obj.ProcessStatus) {
+		return nil
+	}
+	return []webhook{{
+		url:      obj.CallbackUrl,
+		resource: "/postprimaries/" + obj.Mid,
+		mid:      obj.Mid,
+		body:     []byte(mustMarshal(obj)),
+	}}
+}
+
+func completed(before, after ProcessStatusObject) bool {
+	return before.ExecutionStatus != Complete && after.ExecutionStatus == Complete
+}
+
+// A callbackUrl is an absolute http or https URL.
+func (v *validator) callbackUrl(callback string) {
+	if callback == "" {
+		return
+	}
+	u, err := url.Parse(callback)
+	v.check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+		"callbackUrl", "%q is not an http URL", callback)
+}
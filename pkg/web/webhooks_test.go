@@ -0,0 +1,141 @@
+package web
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type callback struct {
+	header http.Header
+	body   []byte
+}
+
+// A receiver that fails the first failures POSTs with a 503.
+func newReceiver(t *testing.T, failures int32) (*httptest.Server, chan callback) {
+	t.Helper()
+	received := make(chan callback, 10)
+	var n int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if atomic.AddInt32(&n, 1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received <- callback{r.Header, body}
+	}))
+	t.Cleanup(server.Close)
+	return server, received
+}
+
+func webhookConfig(t *testing.T) Config {
+	config := fakeConfig(t)
+	config.WebhookSecret = "hunter2"
+	config.WebhookBackoff = time.Millisecond
+	return config
+}
+
+// Polls GET /webhooks until the delivery with id is no longer PENDING.
+func waitDelivery(t *testing.T, st *State, id int64) WebhookDeliveryObject {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, d := range st.Webhooks.Deliveries("") {
+			if d.Id == id && d.Status != DeliveryPending {
+				return d
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("delivery %d never finished", id)
+	return WebhookDeliveryObject{}
+}
+
+func TestDarkcalCallback(t *testing.T) {
+	receiver, received := newReceiver(t, 0)
+	router, st := newTestState(t, webhookConfig(t))
+	serve(router, "POST", "/storages", `{"mid": "m1"}`)
+	darkcal := `{"mid": "m1", "calibFileUrl": "http://localhost:23632/storages/m1/darkcal.h5", "callbackUrl": "` + receiver.URL + `/done"}`
+	if w := serve(router, "POST", "/sockets/1/darkcal/start", darkcal); w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+
+	var cb callback
+	select {
+	case cb = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no callback")
+	}
+	if got, want := cb.header.Get("X-Paws-Signature"), sign([]byte("hunter2"), cb.body); got != want {
+		t.Errorf("signature %q, want %q", got, want)
+	}
+	if got := cb.header.Get("X-Paws-Resource"); got != "/sockets/1/darkcal" {
+		t.Errorf("resource %q", got)
+	}
+	var obj SocketDarkcalObject
+	if err := json.Unmarshal(cb.body, &obj); err != nil {
+		t.Fatal(err)
+	}
+	if obj.Mid != "m1" || obj.ProcessStatus.ExecutionStatus != Complete || obj.ProcessStatus.CompletionStatus != CompletionSuccess {
+		t.Errorf("got %+v", obj)
+	}
+
+	d := waitDelivery(t, st, 1)
+	var deliveries []WebhookDeliveryObject
+	decode(t, serve(router, "GET", "/webhooks?mid=m1", ""), &deliveries)
+	if len(deliveries) != 1 || deliveries[0].Status != DeliveryDelivered || deliveries[0].Attempts != 1 || deliveries[0].ResponseCode != 200 {
+		t.Errorf("got %+v, delivery %+v", deliveries, d)
+	}
+	decode(t, serve(router, "GET", "/webhooks?mid=m2", ""), &deliveries)
+	if len(deliveries) != 0 {
+		t.Errorf("m2 got %+v", deliveries)
+	}
+}
+
+func TestPostprimaryCallbackRetries(t *testing.T) {
+	receiver, received := newReceiver(t, 2)
+	router, st := newTestState(t, webhookConfig(t))
+	serve(router, "POST", "/storages", `{"mid": "m1"}`)
+	body := strings.Replace(postprimaryBody, `"mid": "m1",`, `"mid": "m1", "callbackUrl": "`+receiver.URL+`",`, 1)
+	if w := serve(router, "POST", "/postprimaries", body); w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	d := waitDelivery(t, st, 1)
+	if d.Status != DeliveryDelivered || d.Attempts != 3 || d.Resource != "/postprimaries/m1" || d.Error != "" {
+		t.Errorf("got %+v", d)
+	}
+	var obj PostprimaryObject
+	json.Unmarshal((<-received).body, &obj)
+	if obj.Mid != "m1" || obj.ProcessStatus.ExecutionStatus != Complete {
+		t.Errorf("got %+v", obj)
+	}
+}
+
+func TestCallbackGivesUp(t *testing.T) {
+	receiver, _ := newReceiver(t, 100)
+	config := webhookConfig(t)
+	config.WebhookRetries = 2
+	router, st := newTestState(t, config)
+	serve(router, "POST", "/storages", `{"mid": "m1"}`)
+	darkcal := `{"mid": "m1", "calibFileUrl": "http://localhost:23632/storages/m1/darkcal.h5", "callbackUrl": "` + receiver.URL + `"}`
+	serve(router, "POST", "/sockets/1/darkcal/start", darkcal)
+	d := waitDelivery(t, st, 1)
+	if d.Status != DeliveryFailed || d.Attempts != 3 || d.ResponseCode != http.StatusServiceUnavailable || d.Error == "" {
+		t.Errorf("got %+v", d)
+	}
+}
+
+func TestCallbackUrlIsValidated(t *testing.T) {
+	router := newTestRouter(t, fakeConfig(t))
+	var resp struct{ Errors []FieldError }
+	w := serve(router, "POST", "/sockets/1/darkcal/start", `{"mid": "m1", "callbackUrl": "lims:callback"}`)
+	decode(t, w, &resp)
+	if w.Code != http.StatusBadRequest || len(resp.Errors) != 1 || resp.Errors[0].Field != "callbackUrl" {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+}
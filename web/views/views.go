@@ -0,0 +1,10 @@
+// Package views holds the pages that pa-ws serves as HTML.
+package views
+
+import _ "embed"
+
+// Swagger UI for GET /openapi.json, as an html/template of the URL that
+// Swagger UI itself is loaded from.
+//
+//go:embed swagger.html
+var Swagger string